@@ -0,0 +1,66 @@
+package client
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errBackoffExhausted = errors.New("retries exhausted")
+
+// backoff computes jittered exponential delays between retries, giving up once either maxAttempts consecutive
+// retries have been made or maxElapsed has passed since the first one
+type backoff struct {
+	initial     time.Duration // required
+	maxDelay    time.Duration // required
+	maxAttempts int           // zero for no limit
+	maxElapsed  time.Duration // zero for no limit
+
+	attempts int
+	start    time.Time
+	now      func() time.Time
+	jitter   func() float64
+}
+
+func newBackoff(initial, maxDelay time.Duration, maxAttempts int, maxElapsed time.Duration) *backoff {
+	return &backoff{
+		initial:     initial,
+		maxDelay:    maxDelay,
+		maxAttempts: maxAttempts,
+		maxElapsed:  maxElapsed,
+		now:         time.Now,
+		jitter:      rand.Float64,
+	}
+}
+
+// next returns how long to wait before the next retry, or errBackoffExhausted if we should give up
+func (b *backoff) next() (time.Duration, error) {
+	if b.attempts == 0 {
+		b.start = b.now()
+	}
+	if b.maxAttempts > 0 && b.attempts >= b.maxAttempts {
+		return 0, errors.WithMessagef(errBackoffExhausted, "gave up after %d attempts", b.attempts)
+	}
+	if elapsed := b.now().Sub(b.start); b.maxElapsed > 0 && elapsed >= b.maxElapsed {
+		return 0, errors.WithMessagef(errBackoffExhausted, "gave up after %s", elapsed)
+	}
+
+	delay := b.initial
+	for range b.attempts {
+		delay *= 2
+		if delay >= b.maxDelay || delay <= 0 {
+			delay = b.maxDelay
+			break
+		}
+	}
+	b.attempts++
+	// Wait at least half of the delay so that retries still back off, with the rest randomized so that many
+	// clients dropped at once don't all retry in lockstep
+	return delay/2 + time.Duration(b.jitter()*float64(delay/2)), nil
+}
+
+// reset starts over from the initial delay, e.g. once a retried operation has succeeded
+func (b *backoff) reset() {
+	b.attempts = 0
+}
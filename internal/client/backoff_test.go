@@ -0,0 +1,34 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	now := time.Now()
+	b := newBackoff(time.Second, 5*time.Second, 5, time.Minute)
+	b.now = func() time.Time { return now }
+	b.jitter = func() float64 { return 1 }
+
+	var delays []time.Duration
+	for range 5 {
+		delay, err := b.next()
+		require.NoError(t, err)
+		delays = append(delays, delay)
+	}
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+	_, err := b.next()
+	require.ErrorIs(t, err, errBackoffExhausted)
+
+	// Resetting starts over, until too much time has passed
+	b.reset()
+	delay, err := b.next()
+	require.NoError(t, err)
+	require.Equal(t, time.Second, delay)
+	now = now.Add(time.Minute)
+	_, err = b.next()
+	require.ErrorIs(t, err, errBackoffExhausted)
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// byteCounts tracks bandwidth across all connections. Payload bytes are the messages as read and written, wire bytes
+// what went over the network after compression and framing (and TLS, if any). Wire bytes are only counted for
+// websocket connections.
+type byteCounts struct {
+	payloadRead    atomic.Int64
+	payloadWritten atomic.Int64
+	wireRead       atomic.Int64
+	wireWritten    atomic.Int64
+}
+
+// logAttrs returns the counts as log attributes
+func (b *byteCounts) logAttrs() []any {
+	return []any{
+		"payload_read", b.payloadRead.Load(),
+		"payload_written", b.payloadWritten.Load(),
+		"wire_read", b.wireRead.Load(),
+		"wire_written", b.wireWritten.Load(),
+	}
+}
+
+// countingConn counts the bytes read from and written to a network connection
+type countingConn struct {
+	net.Conn
+	counts *byteCounts
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counts.wireRead.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counts.wireWritten.Add(int64(n))
+	return n, err
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"bufio"
+	"cmp"
 	"context"
 	"fmt"
 	"io"
@@ -8,22 +10,113 @@ import (
 	"os"
 	"time"
 
-	"golang.org/x/sync/errgroup"
-
 	"gholden-go/internal/grammar"
 
-	"github.com/coder/websocket"
+	"golang.org/x/sync/errgroup"
+
 	"github.com/pkg/errors"
 )
 
+// Config configures a Session. The CLI embeds it, so every field is also a flag, and DefaultConfig returns the flags'
+// defaults. No field is required: NewSession uses the default for the address, transport, endpoints, timeouts, retry
+// delays and attempts, and rate limit burst when they're zero, as zero wouldn't work for them. Zero keeps its meaning
+// for everything else, e.g. no limit for ReconnectMaxElapsed and disabled for KeepaliveInterval, so copy them from
+// DefaultConfig to get the defaults.
+type Config struct {
+	Address                string            `help:"Address to bind to, or the SockJS base URL (e.g. https://sim3.psim.us/showdown) for SockJS transports" default:"ws://localhost:8000/showdown/websocket"`
+	Transport              string            `help:"How to connect: websocket, sockjs (falling back to xhr-streaming), or xhr-streaming" enum:"websocket,sockjs,xhr-streaming" default:"websocket"`
+	LoginEndpoints         []string          `name:"login-endpoint" help:"Addresses that serve login with a password, tried in order" default:"https://play.pokemonshowdown.com/api/login"`
+	ActionEndpoints        []string          `name:"action-endpoint" help:"Addresses that serve login assertions and sessions, tried in order" default:"https://play.pokemonshowdown.com/action.php"`
+	LoginRetryInitialDelay time.Duration     `help:"Delay before retrying logins that failed with network or server errors, doubled on every retry" default:"500ms"`
+	LoginRetryMaxDelay     time.Duration     `help:"Maximum delay between login retries" default:"10s"`
+	LoginRetryMaxAttempts  int               `help:"Give up on logging in after retrying every endpoint this many times" default:"3"`
+	SessionDir             string            `help:"Directory to save login sessions in, one file per account, so that restarts don't need the password again. Empty to always log in from scratch"`
+	Timeout                time.Duration     `help:"Timeout for individual dials/reads/writes/etc" default:"30s"`
+	ReconnectInitialDelay  time.Duration     `help:"Delay before the first reconnect attempt, doubled on every failed attempt" default:"1s"`
+	ReconnectMaxDelay      time.Duration     `help:"Maximum delay between reconnect attempts" default:"1m"`
+	ReconnectMaxAttempts   int               `help:"Give up after this many consecutive failed reconnect attempts, 0 for no limit" default:"0"`
+	ReconnectMaxElapsed    time.Duration     `help:"Give up after failing to reconnect for this long, 0 for no limit" default:"15m"`
+	KeepaliveInterval      time.Duration     `help:"Interval between pings to the server, 0 to disable" default:"30s"`
+	ReadIdleTimeout        time.Duration     `help:"Reconnect when nothing, including pongs, is received for this long, 0 to disable" default:"90s"`
+	ShutdownTimeout        time.Duration     `help:"How long to wait for queued messages to be sent when shutting down" default:"10s"`
+	ShutdownPolicy         string            `help:"What to do with joined rooms when shutting down: none, leave them, or forfeit battles and leave" enum:"none,leave,forfeit" default:"none"`
+	RateLimitBurst         int               `help:"Number of messages that can be sent at once before rate limiting kicks in" default:"6"`
+	RateLimitGuest         time.Duration     `help:"Minimum average interval between messages sent as a guest, 0 to disable" default:"600ms"`
+	RateLimitRegistered    time.Duration     `help:"Minimum average interval between messages sent under a chosen name, 0 to disable" default:"300ms"`
+	Proxy                  string            `help:"Proxy URL for the websocket and login, instead of HTTPS_PROXY/HTTP_PROXY from the environment"`
+	CABundle               string            `help:"PEM file of CA certificates to trust instead of the system roots" type:"existingfile"`
+	ClientCert             string            `help:"PEM file of a client certificate to present to the server" type:"existingfile"`
+	ClientKey              string            `help:"PEM file of the client certificate's private key" type:"existingfile"`
+	Header                 map[string]string `help:"Extra header for the websocket handshake and login, e.g. --header User-Agent=gholden"`
+	Subprotocols           []string          `help:"Websocket subprotocols to offer"`
+	Compression            string            `help:"Websocket permessage-deflate compression: disabled, context-takeover (better ratio, more memory), or no-context-takeover" enum:"disabled,context-takeover,no-context-takeover" default:"disabled"`
+	CompressionThreshold   int               `help:"Minimum message size in bytes to compress, 0 for the library default"`
+	MaxMessageSize         int64             `help:"Maximum size in bytes of a received websocket message after decompression, 0 for the library default" default:"16777216"`
+	// Dial connects to the server with a custom transport instead of the one selected by Transport
+	Dial func(ctx context.Context) (Transport, error) `kong:"-"`
+}
+
+// DefaultConfig returns the config the CLI runs with when no flags are given
+func DefaultConfig() Config {
+	return Config{
+		Address:                "ws://localhost:8000/showdown/websocket",
+		Transport:              transportWebsocket,
+		LoginEndpoints:         []string{"https://play.pokemonshowdown.com/api/login"},
+		ActionEndpoints:        []string{"https://play.pokemonshowdown.com/action.php"},
+		LoginRetryInitialDelay: 500 * time.Millisecond,
+		LoginRetryMaxDelay:     10 * time.Second,
+		LoginRetryMaxAttempts:  3,
+		Timeout:                30 * time.Second,
+		ReconnectInitialDelay:  time.Second,
+		ReconnectMaxDelay:      time.Minute,
+		ReconnectMaxElapsed:    15 * time.Minute,
+		KeepaliveInterval:      30 * time.Second,
+		ReadIdleTimeout:        90 * time.Second,
+		ShutdownTimeout:        10 * time.Second,
+		ShutdownPolicy:         string(shutdownNone),
+		RateLimitBurst:         6,
+		RateLimitGuest:         600 * time.Millisecond,
+		RateLimitRegistered:    300 * time.Millisecond,
+		Compression:            "disabled",
+		MaxMessageSize:         16 << 20,
+	}
+}
+
+// withDefaults returns c with the defaults filled in for the zero fields that can't be used as is
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	c.Address = cmp.Or(c.Address, d.Address)
+	c.Transport = cmp.Or(c.Transport, d.Transport)
+	if len(c.LoginEndpoints) == 0 {
+		c.LoginEndpoints = d.LoginEndpoints
+	}
+	if len(c.ActionEndpoints) == 0 {
+		c.ActionEndpoints = d.ActionEndpoints
+	}
+	c.LoginRetryInitialDelay = cmp.Or(c.LoginRetryInitialDelay, d.LoginRetryInitialDelay)
+	c.LoginRetryMaxDelay = cmp.Or(c.LoginRetryMaxDelay, d.LoginRetryMaxDelay)
+	c.LoginRetryMaxAttempts = cmp.Or(c.LoginRetryMaxAttempts, d.LoginRetryMaxAttempts)
+	c.Timeout = cmp.Or(c.Timeout, d.Timeout)
+	c.ReconnectInitialDelay = cmp.Or(c.ReconnectInitialDelay, d.ReconnectInitialDelay)
+	c.ReconnectMaxDelay = cmp.Or(c.ReconnectMaxDelay, d.ReconnectMaxDelay)
+	c.ShutdownTimeout = cmp.Or(c.ShutdownTimeout, d.ShutdownTimeout)
+	c.RateLimitBurst = cmp.Or(c.RateLimitBurst, d.RateLimitBurst)
+	return c
+}
+
 type CLI struct {
-	Address       string        `help:"Address to bind to" default:"ws://localhost:8000/showdown/websocket"`
-	LoginEndpoint string        `help:"Address that serves login" default:"https://play.pokemonshowdown.com/api/login"`
-	Timeout       time.Duration `help:"Timeout for individual dials/reads/writes/etc" default:"30s"`
-	Debug         bool          `help:"Enable debug mode"`
-	Logger        *slog.Logger  `kong:"-"`
-	Stdin         io.Reader     `kong:"-"` // required
-	Stdout        io.Writer     `kong:"-"` // required
+	Config `embed:""`
+	// Credentials are looked up in the order of these flags, with SHOWDOWN_USERNAME and SHOWDOWN_PASSWORD checked
+	// after the flags
+	Username          string       `help:"Name to log in as, a random unregistered name if not found anywhere else"`
+	Password          string       `help:"Password of a registered name"`
+	CredentialCommand string       `help:"Command printing username=... and password=... lines, run with sh like git's credential helpers"`
+	Netrc             string       `help:"Netrc file with an entry for the login server's host" type:"existingfile"`
+	CredentialsFile   string       `help:"JSON file with a username and password" type:"existingfile"`
+	Debug             bool         `help:"Enable debug mode"`
+	Logger            *slog.Logger `kong:"-"`
+	Stdin             io.Reader    `kong:"-"` // required
+	Stdout            io.Writer    `kong:"-"` // required
 }
 
 func (c *CLI) Run(ctx context.Context) error {
@@ -47,49 +140,68 @@ func (c *CLI) Run(ctx context.Context) error {
 		if c.Debug {
 			opts.Level = slog.LevelDebug
 		}
-		c.Logger = slog.New(slog.NewJSONHandler(os.Stdout, opts))
+		// Secrets are hidden from logs even in debug mode
+		c.Logger = slog.New(newRedactHandler(slog.NewJSONHandler(os.Stdout, opts)))
 	}
 
-	// Initialize websocket connection
-	conn, _, err := websocket.Dial(ctx, c.Address, nil /* opts */)
+	creds, err := c.credentialProvider().Credentials(ctx)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
+	}
+	session, err := NewSession(c.Config, creds, c.Logger)
+	if err != nil {
+		return err
 	}
 
-	// Listen for and log incoming messages from the websocket
-	incomingMessages := make(chan grammar.ServerMessage)
-	s := newSubscriber(incomingMessages, c.Logger, c.Timeout)
-	g, ctx := errgroup.WithContext(ctx)
-	g.Go(func() error {
-		err := s.run(ctx, conn)
-		return errors.WithMessage(err, "error running subscriber")
-	})
-
-	// Send some messages to the server
-	outgoingMessages := make(chan grammar.ClientMessage)
-	p := newPublisher(outgoingMessages, c.Timeout, c.Logger)
-	g.Go(func() error {
-		err := p.run(ctx, conn)
-		return errors.WithMessage(err, "error running publisher")
-	})
-
-	controller := newController(controllerOpts{
-		outgoingMessagesCh: outgoingMessages,
-		incomingMessagesCh: incomingMessages,
-		loginEndpoint:      c.LoginEndpoint,
-		timeout:            c.Timeout,
-		logger:             c.Logger,
-		stdin:              c.Stdin,
-		stdout:             c.Stdout,
-	})
+	// The session shuts down gracefully once ctx is done, e.g. on SIGINT, or input fails
+	g, gctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
-		err := controller.handleIncoming(ctx)
-		return errors.WithMessage(err, "error running controller")
+		return session.Run(gctx)
 	})
 	g.Go(func() error {
-		err := controller.prompt(ctx)
+		err := c.prompt(gctx, session)
+		if ctx.Err() != nil {
+			return nil
+		}
 		return errors.WithMessage(err, "error running prompt")
 	})
-
 	return g.Wait()
 }
+
+// prompt sends every line of input as a raw command once the session has logged in
+func (c *CLI) prompt(ctx context.Context, session *Session) error {
+	// Wait until we've logged in for the first time
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case <-session.LoggedIn():
+	}
+
+	c.Logger.InfoContext(ctx, "enter commands")
+	inputCh := make(chan string)
+	scanner := bufio.NewScanner(c.Stdin)
+	go func() {
+		for scanner.Scan() {
+			inputCh <- scanner.Text()
+		}
+		close(inputCh)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case input, ok := <-inputCh:
+			if !ok {
+				return errors.WithMessage(cmp.Or(scanner.Err(), io.EOF), "input channel closed")
+			}
+			c.Logger.InfoContext(ctx, "enter input", "input", input)
+			if err := session.Send(ctx, grammar.RawCommand{Command: input}); err != nil {
+				if ctx.Err() != nil {
+					return errors.WithStack(ctx.Err())
+				}
+				c.Logger.WarnContext(ctx, "failed to send input", "input", input, "error", err)
+			}
+		}
+	}
+}
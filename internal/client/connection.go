@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// connect dials the server and runs the subscriber, publisher and login flow on the connection, reconnecting with
+// backoff whenever the connection drops. The queues feeding the subscriber and publisher outlive any one connection,
+// so messages queued while we're disconnected are sent once we reconnect. Closing closing closes the connection with
+// a normal closure and returns nil.
+func (s *Session) connect(ctx context.Context, closing <-chan struct{}) error {
+	c := s.config
+	b := newBackoff(c.ReconnectInitialDelay, c.ReconnectMaxDelay, c.ReconnectMaxAttempts, c.ReconnectMaxElapsed)
+	for {
+		activity := make(chan struct{}, 1)
+		dialCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+		t, err := s.dialTransport(dialCtx, activity)
+		cancel()
+		if err == nil {
+			s.logger.InfoContext(ctx, "connected", "address", c.Address, "transport", c.Transport)
+			var generation uint64
+			generation, err = s.runConnection(ctx, t, activity, closing)
+			// Servers that accept connections only to drop them, e.g. because we're throttled, count as failed
+			// attempts unless we've logged in
+			if s.controller.state.loggedIn(generation) {
+				b.reset()
+			}
+			s.logger.InfoContext(ctx, "bandwidth", s.counts.logAttrs()...)
+		}
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		select {
+		case <-closing:
+			return nil
+		default:
+		}
+		// Reconnecting would only fail to log in again, e.g. with the same wrong password
+		if IsPermanentLoginError(err) {
+			return err
+		}
+
+		delay, backoffErr := b.next()
+		if backoffErr != nil {
+			return errors.WithMessage(errors.WithStack(err), backoffErr.Error())
+		}
+		s.logger.WarnContext(ctx, "connection lost, reconnecting", "error", errors.WithStack(err), "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-closing:
+			return nil
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+	}
+}
+
+// runConnection runs everything scoped to a single connection until the connection drops or goes stale, closing is
+// closed, or ctx is done. It returns the connection's generation.
+func (s *Session) runConnection(
+	ctx context.Context,
+	t Transport,
+	activity chan struct{},
+	closing <-chan struct{},
+) (uint64, error) {
+	defer func() {
+		// Unless we're closing, the connection is already broken or ctx is done, so skip the close handshake
+		if err := closeNow(t); err != nil {
+			s.logger.DebugContext(ctx, "error closing connection", "error", errors.WithStack(err))
+		}
+	}()
+	generation := s.controller.state.newConnection()
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := s.subscriber.run(ctx, t, generation, activity)
+		return errors.WithMessage(err, "error running subscriber")
+	})
+	loggedIn := make(chan struct{})
+	g.Go(func() error {
+		err := s.publisher.run(ctx, t, loggedIn)
+		return errors.WithMessage(err, "error running publisher")
+	})
+	g.Go(func() error {
+		err := s.controller.authenticate(ctx)
+		return errors.WithMessage(err, "failed to login")
+	})
+	g.Go(func() error {
+		if err := s.controller.waitLoggedIn(ctx, generation); err != nil {
+			return err
+		}
+		close(loggedIn)
+		return nil
+	})
+	g.Go(func() error {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-closing:
+		}
+		// The subscriber keeps reading until the server answers our close frame, as cancelling a read drops the
+		// connection
+		if err := t.Close(); err != nil {
+			return errors.Wrap(err, "failed to close connection")
+		}
+		return errShutdown
+	})
+	if p, ok := t.(pinger); ok && s.config.KeepaliveInterval > 0 {
+		g.Go(func() error {
+			return keepalive(ctx, p, activity, s.config.KeepaliveInterval, s.config.Timeout)
+		})
+	}
+	return generation, g.Wait()
+}
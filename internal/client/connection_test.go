@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gholden-go/internal/grammar"
+
+	"github.com/coder/websocket"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_Reconnect(t *testing.T) {
+	doneCh := make(chan struct{})
+	helper := &loginHelper{
+		doneCh:  doneCh,
+		loginCh: make(chan loginInfo),
+	}
+	ls := httptest.NewServer(helper.loginServer(t))
+	t.Cleanup(ls.Close)
+	// More drops than ReconnectMaxAttempts, which only counts consecutive connections that failed to log in
+	const connections = 5
+	confirmed := make(chan struct{})
+	drop := make(chan struct{})
+	ws := httptest.NewServer(helper.websocketReconnect(t, connections, confirmed, drop))
+	t.Cleanup(ws.Close)
+
+	session, err := NewSession(Config{
+		Address:               ws.URL,
+		LoginEndpoints:        []string{ls.URL},
+		ActionEndpoints:       []string{ls.URL},
+		Timeout:               time.Second,
+		ReconnectInitialDelay: time.Millisecond,
+		ReconnectMaxDelay:     10 * time.Millisecond,
+		ReconnectMaxAttempts:  3,
+	}, Credentials{}, slogt.New(t, slogt.JSON()))
+	require.NoError(t, err)
+	// We don't care about the error itself as long as we've logged in on every connection
+	runInBackground(t, session.Run)
+	for range connections - 1 {
+		select {
+		case <-confirmed:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for login")
+		}
+		// Only drop the connection once the client has seen that it's logged in
+		require.Eventually(t, func() bool {
+			_, named := session.controller.state.user()
+			return named
+		}, 5*time.Second, time.Millisecond)
+		drop <- struct{}{}
+	}
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for login after reconnecting")
+	}
+}
+
+func TestSession_ReconnectGivesUp(t *testing.T) {
+	var connections atomic.Int32
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		require.NoError(t, err)
+		connections.Add(1)
+		// Accept and drop right away, like a server throttling us would
+		require.NoError(t, c.CloseNow())
+	}))
+	t.Cleanup(ws.Close)
+
+	session, err := NewSession(Config{
+		Address:               ws.URL,
+		Timeout:               time.Second,
+		ReconnectInitialDelay: time.Millisecond,
+		ReconnectMaxDelay:     10 * time.Millisecond,
+		ReconnectMaxAttempts:  3,
+	}, Credentials{}, slogt.New(t, slogt.JSON()))
+	require.NoError(t, err)
+	runErr := make(chan error)
+	go func() {
+		runErr <- session.Run(t.Context())
+	}()
+	select {
+	case err := <-runErr:
+		require.ErrorContains(t, err, "gave up after 3 attempts")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the session to give up")
+	}
+	require.Equal(t, int32(4), connections.Load())
+}
+
+func TestSession_ReconnectSendsAfterLogin(t *testing.T) {
+	ls := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte("assertion-Bot"))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ls.Close)
+	drop := make(chan struct{})
+	reconnected := make(chan struct{})
+	received := make(chan string)
+	confirm := make(chan struct{})
+	var connections atomic.Int32
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		require.NoError(t, err)
+		defer c.CloseNow()
+		first := connections.Add(1) == 1
+		if !first {
+			close(reconnected)
+		}
+		require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte("|challstr|4|abc")))
+		for {
+			_, msg, err := c.Read(t.Context())
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(string(msg), "|/trn ") {
+				if first {
+					require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte("|updateuser| Bot|1|1|{}")))
+					<-drop
+					return
+				}
+				received <- string(msg)
+				<-confirm
+				require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte("|updateuser| Bot|1|1|{}")))
+				continue
+			}
+			received <- string(msg)
+		}
+	}))
+	t.Cleanup(ws.Close)
+
+	session, err := NewSession(Config{
+		Address:               ws.URL,
+		LoginEndpoints:        []string{ls.URL + "/api/login"},
+		ActionEndpoints:       []string{ls.URL + "/action.php"},
+		Timeout:               time.Second,
+		ReconnectInitialDelay: time.Millisecond,
+		ReconnectMaxDelay:     10 * time.Millisecond,
+		ShutdownTimeout:       time.Second,
+	}, Credentials{Username: "Bot"}, slogt.New(t, slogt.JSON()))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(t.Context())
+	runErr := make(chan error)
+	go func() {
+		runErr <- session.Run(ctx)
+	}()
+	// Drop the first connection once the client has seen the login
+	select {
+	case <-session.LoggedIn():
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for login")
+	}
+	close(drop)
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting to reconnect")
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- session.Send(ctx, grammar.RawCommand{Command: "|/join lobby"})
+	}()
+	// The message waits for the login on the new connection, rather than going out as a guest
+	require.Equal(t, "|/trn Bot,0,assertion-Bot", <-received)
+	select {
+	case <-received:
+		require.FailNow(t, "message sent before the login was confirmed")
+	case err := <-sent:
+		require.FailNow(t, "message reported as sent before the login was confirmed", "error: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(confirm)
+	require.Equal(t, "|/join lobby", <-received)
+	require.NoError(t, <-sent)
+
+	cancel()
+	require.NoError(t, <-runErr)
+}
+
+// websocketReconnect expects a login on each connection, and drops the first connections-1 connections once it's
+// confirmed the login, signalled on confirmed, and has been told to on drop
+func (h *loginHelper) websocketReconnect(
+	t *testing.T,
+	connections int,
+	confirmed chan<- struct{},
+	drop <-chan struct{},
+) http.HandlerFunc {
+	t.Helper()
+	var count atomic.Int32
+	return h.websocketServer(t, nil, nil, func(c *websocket.Conn, info loginInfo) {
+		confirmLogin(t, c, info)
+		if int(count.Add(1)) < connections {
+			// Drop the connection without a close handshake, like a server restart would
+			confirmed <- struct{}{}
+			<-drop
+			require.NoError(t, c.CloseNow())
+			return
+		}
+		close(h.doneCh)
+	})
+}
@@ -1,59 +1,76 @@
 package client
 
 import (
-	"bufio"
-	"bytes"
 	"cmp"
 	"context"
-	"encoding/json"
-	"io"
 	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"gholden-go/internal/grammar"
 
-	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
 type controller struct {
-	outgoingMessagesCh chan<- grammar.ClientMessage
-	incomingMessagesCh <-chan grammar.ServerMessage
+	loginMessagesCh    chan<- *outgoing
+	outgoingMessagesCh chan<- *outgoing
+	priorityMessagesCh chan<- *outgoing
+	incomingMessagesCh <-chan incomingMessage
 	httpClient         *http.Client
-	loginEndpoint      string
+	timeout            time.Duration
+	loginEndpoints     []string
+	actionEndpoints    []string
+	loginBackoff       func() *backoff
 	state              *state
-	logger             *slog.Logger
-	stdin              io.Reader
-	stdout             io.Writer
+	events             *bus
+	username           string
+	password           string
+	sid                *sidStore
+	// loggedIn is closed once the server has confirmed our first login
+	loggedIn     chan struct{}
+	loggedInOnce sync.Once
+	logger       *slog.Logger
 }
 
 type controllerOpts struct {
-	outgoingMessagesCh chan<- grammar.ClientMessage // required
-	incomingMessagesCh <-chan grammar.ServerMessage // required
-	loginEndpoint      string                       // required
-	timeout            time.Duration                // required
-	logger             *slog.Logger                 // required
-	stdin              io.Reader                    // required
-	stdout             io.Writer                    // required
+	loginMessagesCh    chan<- *outgoing       // required
+	outgoingMessagesCh chan<- *outgoing       // required
+	priorityMessagesCh chan<- *outgoing       // required
+	incomingMessagesCh <-chan incomingMessage // required
+	httpClient         *http.Client           // required
+	loginEndpoints     []string               // required
+	actionEndpoints    []string               // required
+	loginBackoff       func() *backoff        // required
+	timeout            time.Duration          // required
+	username           string                 // required
+	password           string                 // empty for unregistered names
+	sid                *sidStore              // required
+	events             *bus                   // required
+	logger             *slog.Logger           // required
 }
 
 func newController(opts controllerOpts) *controller {
 	return &controller{
+		loginMessagesCh:    opts.loginMessagesCh,
 		outgoingMessagesCh: opts.outgoingMessagesCh,
+		priorityMessagesCh: opts.priorityMessagesCh,
 		incomingMessagesCh: opts.incomingMessagesCh,
-		httpClient: &http.Client{
-			Timeout: opts.timeout,
-		},
-		loginEndpoint: opts.loginEndpoint,
-		state: &state{
-			challstr: challstr{
-				set: make(chan struct{}),
-			},
-		},
-		logger: opts.logger,
-		stdin:  opts.stdin,
-		stdout: opts.stdout,
+		httpClient:         opts.httpClient,
+		timeout:            opts.timeout,
+		loginEndpoints:     opts.loginEndpoints,
+		actionEndpoints:    opts.actionEndpoints,
+		loginBackoff:       opts.loginBackoff,
+		state:              newState(),
+		username:           opts.username,
+		password:           opts.password,
+		sid:                opts.sid,
+		events:             opts.events,
+		loggedIn:           make(chan struct{}),
+		logger:             opts.logger,
 	}
 }
 
@@ -63,130 +80,134 @@ func (c *controller) handleIncoming(ctx context.Context) error {
 		case <-ctx.Done():
 			return errors.WithStack(ctx.Err())
 		case msg := <-c.incomingMessagesCh:
-			c.logger.DebugContext(ctx, "Received incoming message", "message", msg)
-			for _, line := range msg.Lines {
+			c.logger.DebugContext(ctx, "Received incoming message", "message", msg.message)
+			room := msg.message.Room()
+			for _, line := range msg.message.Lines {
 				if line.Message == nil {
 					c.logger.WarnContext(ctx, "Received line without a message", "line", line)
 					continue
 				}
-				switch {
-				case line.Message.ChallstrMessage != nil:
-					if err := c.state.setChallstr(line.Message.ChallstrMessage.Challstr); err != nil {
-						c.logger.WarnContext(ctx, "Error setting challstr value", "error", errors.WithStack(err))
+				if err := c.handleLine(msg.generation, room, line.Message); err != nil {
+					if errors.Is(err, errStaleConnection) {
+						c.logger.DebugContext(ctx, "ignoring message from a previous connection", "error", err)
 						continue
 					}
-				default:
-					c.logger.DebugContext(ctx, "unsupported message", "message", line)
+					c.logger.WarnContext(ctx, "Error handling message", "message", line, "error", errors.WithStack(err))
+				}
+				// Subscribers see the state the line left us in, e.g. Rooms includes the room of an init
+				if err := c.events.publish(ctx, newEvent(room, line.Message)); err != nil {
+					return err
 				}
 			}
 		}
 	}
 }
 
-func (c *controller) prompt(ctx context.Context) error {
-	// Wait for the challstr to be ready
-	select {
-	case <-ctx.Done():
-		return errors.WithStack(ctx.Err())
-	case <-c.state.challstr.set:
+// handleLine updates the state of the connection the message was read from. room is the room the message was sent to,
+// or empty for global messages.
+func (c *controller) handleLine(generation uint64, room string, msg *grammar.Message) error {
+	switch {
+	case msg.ChallstrMessage != nil:
+		return c.state.setChallstr(generation, msg.ChallstrMessage.Challstr)
+	case msg.UnknownMessage != nil:
+		switch msg.UnknownMessage.Command {
+		case "updateuser":
+			user, named, err := parseUpdateUser(msg.UnknownMessage.Data)
+			if err != nil {
+				return err
+			}
+			return c.state.setUser(generation, user, named)
+		case "init":
+			return c.state.addRoom(generation, cmp.Or(room, lobby))
+		case "deinit":
+			return c.state.removeRoom(generation, cmp.Or(room, lobby))
+		case "nametaken":
+			// |nametaken|USERNAME|MESSAGE
+			_, message, _ := strings.Cut(msg.UnknownMessage.Data, "|")
+			return c.state.setNameTaken(generation, message)
+		}
 	}
+	c.logger.Debug("unsupported message", "message", msg)
+	return nil
+}
 
-	login := loginInput{
-		Name: "test" + uuid.New().String()[:12], // generate a random (most likely unused) username for now
-		// NB: The password field must exist but doesn't actually matter unless the username is already registered
-		Pass:     "1234",
-		Challstr: c.state.challstr.challstr,
-	}
-	c.logger.InfoContext(ctx, "logging in", "username", login.Name)
-	if err := c.login(ctx, login); err != nil {
-		return errors.WithMessage(err, "failed to login")
+// lobby is the room messages without a room ID are sent to
+const lobby = "lobby"
+
+// parseUpdateUser parses the data of `|updateuser|USER|NAMED|AVATAR|SETTINGS`, where USER is prefixed by the user's
+// group symbol, e.g. ` Guest 123` or `+Zarel`
+func parseUpdateUser(data string) (string, bool, error) {
+	fields := strings.Split(data, "|")
+	if len(fields) < 2 {
+		return "", false, errors.Errorf("updateuser: expected at least 2 fields, got %q", data)
 	}
+	_, size := utf8.DecodeRuneInString(fields[0])
+	return strings.TrimSpace(fields[0][size:]), fields[1] == "1", nil
+}
 
-	c.logger.InfoContext(ctx, "enter commands")
-	inputCh := make(chan string)
-	scanner := bufio.NewScanner(c.stdin)
-	go func() {
-		for scanner.Scan() {
-			inputCh <- scanner.Text()
+// authenticate logs in with the challstr of the current connection, and again whenever the server sends a new one.
+// It fails with ErrNameTaken if the server refuses the name we logged in with.
+func (c *controller) authenticate(ctx context.Context) error {
+	var last challstrKey
+	for {
+		key, challstr, err := c.state.waitChallstr(ctx, last)
+		if err != nil {
+			return err
 		}
-		close(inputCh)
-	}()
+		last = key
 
-	for {
-		select {
-		case <-ctx.Done():
-			return errors.WithStack(ctx.Err())
-		case input, ok := <-inputCh:
-			if !ok {
-				return errors.WithMessage(cmp.Or(scanner.Err(), io.EOF), "input channel closed")
-			}
-			c.logger.InfoContext(ctx, "enter input", "input", input)
-			c.outgoingMessagesCh <- grammar.RawCommand{Command: input}
+		login := loginInput{
+			Name:     c.username,
+			Pass:     c.password,
+			Challstr: challstr,
+		}
+		c.logger.InfoContext(ctx, "logging in", "username", login.Name)
+		if err := c.login(ctx, login); err != nil {
+			return err
 		}
 	}
 }
 
-type loginInput struct {
-	Name     string `json:"name"`     // required
-	Pass     string `json:"pass"`     // required
-	Challstr string `json:"challstr"` // required
-}
-
-type loginResponse struct {
-	Assertion string `json:"assertion"`
+// waitLoggedIn waits until the server confirms that we've logged in on the given connection
+func (c *controller) waitLoggedIn(ctx context.Context, generation uint64) error {
+	if err := c.state.waitLoggedIn(ctx, generation); err != nil {
+		return err
+	}
+	c.loggedInOnce.Do(func() { close(c.loggedIn) })
+	return nil
 }
 
-// login logs in to Showdown following the guidance in the protocol documentation:
-// https://github.com/smogon/pokemon-showdown/blob/master/PROTOCOL.md
-func (c *controller) login(ctx context.Context, input loginInput) error {
-	// From docs:
-	// you'll need to make an HTTP POST request to https://play.pokemonshowdown.com/api/login with the data
-	// name=USERNAME&pass=PASSWORD&challstr=CHALLSTR
-	// USERNAME is your username and PASSWORD is your password, and CHALLSTR is the value you got from |challstr|.
-	// Note that CHALLSTR contains | characters.
-	c.logger.DebugContext(ctx, "Sending login request", "username", input.Name, "challstr", input.Challstr)
-	body, err := json.Marshal(input)
-	if err != nil {
-		return errors.Wrap(err, "failed to marshal login input")
-	}
-	req, err := http.NewRequestWithContext(ctx, "POST", c.loginEndpoint, bytes.NewReader(body))
-	if err != nil {
-		return errors.Wrap(err, "failed to create login request")
-	}
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return errors.Wrap(err, "failed to send login request")
+// enqueue queues msg for the publisher, on the login queue for logins and on the priority queue if it shouldn't wait
+// behind other messages. The returned channel receives nil once msg has been written to the connection, which for
+// anything but logins is only once the server has confirmed that we're logged in, or the error that stopped it from
+// being written.
+func (c *controller) enqueue(ctx context.Context, msg grammar.ClientMessage) (<-chan error, error) {
+	queue := c.outgoingMessagesCh
+	switch {
+	case isLogin(msg):
+		queue = c.loginMessagesCh
+	case isPriority(msg):
+		queue = c.priorityMessagesCh
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			c.logger.ErrorContext(context.Background(), "failed to close response body", "error", errors.WithStack(err))
-		}
-	}()
-	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("login request failed with status %s", resp.Status)
+	out := newOutgoing(msg)
+	select {
+	case queue <- out:
+		return out.result, nil
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
 	}
-	b, err := io.ReadAll(resp.Body)
+}
+
+// send queues msg and waits until it has been written to the connection
+func (c *controller) send(ctx context.Context, msg grammar.ClientMessage) error {
+	result, err := c.enqueue(ctx, msg)
 	if err != nil {
-		return errors.Wrap(err, "failed to read response body")
+		return err
 	}
-	c.logger.DebugContext(ctx, "response from login", "code", resp.StatusCode, "body", string(b))
-	var l loginResponse
-	// Body is prefixed by a `]` character, per the docs https://github.com/smogon/pokemon-showdown/blob/master/PROTOCOL.md
-	if err := json.Unmarshal(bytes.TrimPrefix(b, []byte("]")), &l); err != nil {
-		return errors.Wrap(err, "failed to unmarshal login response")
-	}
-
-	// From docs:
-	// Finish logging in (or renaming) by sending:
-	// /trn USERNAME,0,ASSERTION where USERNAME is your desired username and ASSERTION is data.assertion
 	select {
-	case c.outgoingMessagesCh <- grammar.Rename{
-		Username:  input.Name,
-		Assertion: l.Assertion,
-	}:
-		c.logger.DebugContext(ctx, "sent login command to socket")
+	case err := <-result:
+		return err
 	case <-ctx.Done():
-		return errors.Wrap(ctx.Err(), "failed to send login command to socket")
+		return errors.WithStack(ctx.Err())
 	}
-	return nil
 }
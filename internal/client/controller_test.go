@@ -0,0 +1,22 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpdateUser(t *testing.T) {
+	user, named, err := parseUpdateUser(` Guest 60|0|1|{"blockChallenges":false}`)
+	require.NoError(t, err)
+	require.Equal(t, "Guest 60", user)
+	require.False(t, named)
+
+	user, named, err = parseUpdateUser(`+Zarel|1|zarel|{}`)
+	require.NoError(t, err)
+	require.Equal(t, "Zarel", user)
+	require.True(t, named)
+
+	_, _, err = parseUpdateUser(`Zarel`)
+	require.Error(t, err)
+}
@@ -0,0 +1,236 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gholden-go/internal/grammar"
+
+	"github.com/pkg/errors"
+)
+
+// Credentials are the account a Session logs in as
+type Credentials struct {
+	// Username defaults to a random, most likely unused, name
+	Username string `json:"username"`
+	// Password is only needed for registered names
+	Password string `json:"password"`
+}
+
+// CredentialProvider looks up the credentials to log in with, e.g. from a secret store. Providers with nothing
+// configured return empty credentials rather than an error.
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// StaticCredentials are credentials known up front, e.g. from flags
+type StaticCredentials Credentials
+
+func (s StaticCredentials) Credentials(context.Context) (Credentials, error) {
+	return Credentials(s), nil
+}
+
+// EnvCredentials reads credentials from environment variables, SHOWDOWN_USERNAME and SHOWDOWN_PASSWORD by default
+type EnvCredentials struct {
+	UsernameVar string
+	PasswordVar string
+}
+
+func (e EnvCredentials) Credentials(context.Context) (Credentials, error) {
+	return Credentials{
+		Username: os.Getenv(cmp.Or(e.UsernameVar, "SHOWDOWN_USERNAME")),
+		Password: os.Getenv(cmp.Or(e.PasswordVar, "SHOWDOWN_PASSWORD")),
+	}, nil
+}
+
+// FileCredentials reads credentials from a JSON file, e.g. {"username": "...", "password": "..."}
+type FileCredentials struct {
+	Path string
+}
+
+func (f FileCredentials) Credentials(context.Context) (Credentials, error) {
+	if f.Path == "" {
+		return Credentials{}, nil
+	}
+	b, err := os.ReadFile(f.Path)
+	if err != nil {
+		return Credentials{}, errors.Wrap(err, "failed to read credentials file")
+	}
+	var creds Credentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return Credentials{}, errors.Wrapf(err, "invalid credentials file %s", f.Path)
+	}
+	return creds, nil
+}
+
+// NetrcCredentials looks up the login and password for Machine in a netrc file, falling back to its default entry
+type NetrcCredentials struct {
+	Path    string
+	Machine string
+}
+
+func (n NetrcCredentials) Credentials(context.Context) (Credentials, error) {
+	if n.Path == "" {
+		return Credentials{}, nil
+	}
+	b, err := os.ReadFile(n.Path)
+	if err != nil {
+		return Credentials{}, errors.Wrap(err, "failed to read netrc file")
+	}
+	creds, err := parseNetrc(b, n.Machine)
+	return creds, errors.WithMessagef(err, "invalid netrc file %s", n.Path)
+}
+
+// parseNetrc returns the login and password of the first entry for machine, or of the default entry if there's none
+func parseNetrc(b []byte, machine string) (Credentials, error) {
+	var found, fallback, current *Credentials
+	tokens := strings.Fields(string(b))
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		value := func() (string, error) {
+			i++
+			if i == len(tokens) {
+				return "", errors.Errorf("%s without a value", token)
+			}
+			return tokens[i], nil
+		}
+		switch token {
+		case "machine":
+			name, err := value()
+			if err != nil {
+				return Credentials{}, err
+			}
+			current = &Credentials{}
+			if name == machine && found == nil {
+				found = current
+			}
+		case "default":
+			current = &Credentials{}
+			if fallback == nil {
+				fallback = current
+			}
+		case "login", "password", "account":
+			v, err := value()
+			if err != nil {
+				return Credentials{}, err
+			}
+			if current == nil {
+				return Credentials{}, errors.Errorf("%s outside of a machine entry", token)
+			}
+			switch token {
+			case "login":
+				current.Username = v
+			case "password":
+				current.Password = v
+			}
+		case "macdef":
+			// Macros run until the next blank line, which Fields can't see, and are only used by ftp anyway
+			return Credentials{}, errors.New("macdef is not supported")
+		default:
+			return Credentials{}, errors.Errorf("unexpected token %q", token)
+		}
+	}
+	switch {
+	case found != nil:
+		return *found, nil
+	case fallback != nil:
+		return *fallback, nil
+	}
+	return Credentials{}, nil
+}
+
+// CommandCredentials runs a command that prints credentials on stdout, like git's credential helpers. The command is
+// run with sh, given `protocol=...` and `host=...` lines for the login server on stdin, and prints `username=...` and
+// `password=...` lines.
+type CommandCredentials struct {
+	Command  string
+	Endpoint string
+}
+
+func (c CommandCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	if c.Command == "" {
+		return Credentials{}, nil
+	}
+	var stdin bytes.Buffer
+	if u, err := url.Parse(c.Endpoint); err == nil && u.Host != "" {
+		fmt.Fprintf(&stdin, "protocol=%s\nhost=%s\n", u.Scheme, u.Host)
+	}
+	stdin.WriteString("\n")
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stdin = &stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Credentials{}, errors.Wrap(err, "credential command failed")
+	}
+	var creds Credentials
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			// A blank line ends the output
+			break
+		}
+		switch key {
+		case "username":
+			creds.Username = value
+		case "password":
+			creds.Password = value
+		}
+	}
+	return creds, nil
+}
+
+// ChainCredentials takes the username from the first provider that has one, along with its password. Without a
+// password, it's taken from the next provider with the same username, compared as IDs, so that the username and
+// password never come from different accounts. Passwords without a username are ignored. Providers after the password
+// has been found aren't asked.
+type ChainCredentials []CredentialProvider
+
+func (c ChainCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	var creds Credentials
+	for _, provider := range c {
+		if creds.Password != "" {
+			break
+		}
+		next, err := provider.Credentials(ctx)
+		if err != nil {
+			return Credentials{}, err
+		}
+		switch {
+		case next.Username == "":
+		case creds.Username == "":
+			creds = next
+		case grammar.ToID(next.Username) == grammar.ToID(creds.Username):
+			creds.Password = next.Password
+		}
+	}
+	return creds, nil
+}
+
+// credentialProvider returns the provider for the CLI flags, looking at the flags first, then the environment, the
+// credential command, the netrc file, and finally the credentials file
+func (c *CLI) credentialProvider() CredentialProvider {
+	var endpoint, host string
+	if len(c.LoginEndpoints) > 0 {
+		endpoint = c.LoginEndpoints[0]
+	}
+	if u, err := url.Parse(endpoint); err == nil {
+		host = u.Hostname()
+	}
+	return ChainCredentials{
+		StaticCredentials{Username: c.Username, Password: c.Password},
+		EnvCredentials{},
+		CommandCredentials{Command: c.CredentialCommand, Endpoint: endpoint},
+		NetrcCredentials{Path: c.Netrc, Machine: host},
+		FileCredentials{Path: c.CredentialsFile},
+	}
+}
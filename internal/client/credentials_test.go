@@ -0,0 +1,78 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLI_credentialProvider(t *testing.T) {
+	t.Setenv("SHOWDOWN_USERNAME", "")
+	t.Setenv("SHOWDOWN_PASSWORD", "")
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"username": "Bot", "password": "hunter2"}`), 0o600))
+
+	creds, err := (&CLI{CredentialsFile: file}).credentialProvider().Credentials(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, Credentials{Username: "Bot", Password: "hunter2"}, creds)
+
+	// A missing password is only filled in for the same account
+	creds, err = (&CLI{Username: "bot", CredentialsFile: file}).credentialProvider().Credentials(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, Credentials{Username: "bot", Password: "hunter2"}, creds)
+
+	// The environment takes precedence over the file, without mixing in a password from a flag or another account
+	t.Setenv("SHOWDOWN_USERNAME", "EnvBot")
+	creds, err = (&CLI{Password: "flag", CredentialsFile: file}).credentialProvider().Credentials(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, Credentials{Username: "EnvBot"}, creds)
+
+	// The command isn't run once the flags have everything
+	c := &CLI{Username: "Bot", Password: "flag", CredentialCommand: "exit 1"}
+	creds, err = c.credentialProvider().Credentials(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, Credentials{Username: "Bot", Password: "flag"}, creds)
+
+	require.NoError(t, os.WriteFile(file, []byte(`Bot:hunter2`), 0o600))
+	_, err = (&CLI{CredentialsFile: file}).credentialProvider().Credentials(t.Context())
+	require.ErrorContains(t, err, "invalid credentials file")
+}
+
+func TestParseNetrc(t *testing.T) {
+	const netrc = `
+machine github.com login octocat password ghp_123
+machine play.pokemonshowdown.com
+	login Bot
+	account ignored
+	password hunter2
+default login anonymous password guest
+`
+	creds, err := parseNetrc([]byte(netrc), "play.pokemonshowdown.com")
+	require.NoError(t, err)
+	require.Equal(t, Credentials{Username: "Bot", Password: "hunter2"}, creds)
+
+	creds, err = parseNetrc([]byte(netrc), "sim3.psim.us")
+	require.NoError(t, err)
+	require.Equal(t, Credentials{Username: "anonymous", Password: "guest"}, creds)
+
+	_, err = parseNetrc([]byte("login Bot"), "play.pokemonshowdown.com")
+	require.EqualError(t, err, "login outside of a machine entry")
+	_, err = parseNetrc([]byte("machine play.pokemonshowdown.com login"), "play.pokemonshowdown.com")
+	require.EqualError(t, err, "login without a value")
+}
+
+func TestCommandCredentials(t *testing.T) {
+	// Like git's credential helpers, the command is told which host it's for
+	c := CommandCredentials{
+		Command:  `grep -q '^host=play.pokemonshowdown.com$' && printf 'username=Bot\npassword=hunter 2\n\nignored=1\n'`,
+		Endpoint: "https://play.pokemonshowdown.com/api/login",
+	}
+	creds, err := c.Credentials(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, Credentials{Username: "Bot", Password: "hunter 2"}, creds)
+
+	_, err = CommandCredentials{Command: "exit 3"}.Credentials(t.Context())
+	require.ErrorContains(t, err, "credential command failed: exit status 3")
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"gholden-go/internal/grammar"
+
+	"github.com/pkg/errors"
+)
+
+// Event is a line received from the server
+type Event struct {
+	// Room is the room the line was sent to, or empty for global messages
+	Room string
+	// Type is the message's command, e.g. challstr or init, and empty for lines without one such as plain chat
+	Type    string
+	Message *grammar.Message
+}
+
+// newEvent returns the event for a line sent to room
+func newEvent(room string, msg *grammar.Message) Event {
+	e := Event{Room: room, Message: msg}
+	switch {
+	case msg.ChallstrMessage != nil:
+		e.Type = "challstr"
+	case msg.UnknownMessage != nil:
+		e.Type = msg.UnknownMessage.Command
+	}
+	return e
+}
+
+// Handler handles events of a subscription. ctx is done once the subscription is unsubscribed or the session stops.
+type Handler func(ctx context.Context, event Event)
+
+// Filter selects the events a subscription handles. Any func(Event) bool can be used as a predicate.
+type Filter func(Event) bool
+
+// MessageTypes selects events of any of the given types
+func MessageTypes(types ...string) Filter {
+	return func(e Event) bool {
+		return slices.Contains(types, e.Type)
+	}
+}
+
+// InRooms selects events sent to any of the given rooms. The empty room selects global messages.
+func InRooms(rooms ...string) Filter {
+	return func(e Event) bool {
+		return slices.Contains(rooms, e.Room)
+	}
+}
+
+// AllOf selects events selected by every filter
+func AllOf(filters ...Filter) Filter {
+	return func(e Event) bool {
+		for _, f := range filters {
+			if !f(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Overflow is what happens to events for a subscription whose buffer is full
+type Overflow int
+
+const (
+	// Block waits for the handler to catch up. This holds up every other subscription and reading from the
+	// connection, so the handler must not wait for later events.
+	Block Overflow = iota
+	// DropNewest discards events that don't fit in the buffer
+	DropNewest
+	// DropOldest discards the oldest buffered event to make room for the new one
+	DropOldest
+)
+
+// defaultEventBuffer is how many events can wait for a handler when SubscribeOptions.Buffer isn't set
+const defaultEventBuffer = 64
+
+// SubscribeOptions configures which events a subscription handles, and what happens when its handler falls behind
+type SubscribeOptions struct {
+	// Filter selects the events to handle, nil for every event
+	Filter Filter
+	// Buffer is how many events can wait for the handler before Overflow applies, 64 if 0
+	Buffer   int
+	Overflow Overflow
+}
+
+// Subscription delivers events to its handler one at a time, in the order they were received
+type Subscription struct {
+	bus      *bus
+	handler  Handler
+	filter   Filter
+	overflow Overflow
+	queue    chan Event
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// Unsubscribe stops the subscription. Events already being handled aren't interrupted other than by cancelling the
+// handler's context, but no others are handled.
+func (s *Subscription) Unsubscribe() {
+	s.cancel()
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.subscriptions = slices.DeleteFunc(s.bus.subscriptions, func(other *Subscription) bool {
+		return other == s
+	})
+}
+
+// run handles events until the subscription stops
+func (s *Subscription) run() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case e := <-s.queue:
+			// Don't handle anything after unsubscribing, even if both were ready
+			if s.ctx.Err() != nil {
+				return
+			}
+			s.handler(s.ctx, e)
+		}
+	}
+}
+
+// bus dispatches events to subscriptions
+type bus struct {
+	mu sync.Mutex
+	// subscriptions are offered every event in the order they subscribed
+	subscriptions []*Subscription
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+	logger        *slog.Logger
+}
+
+func newBus(logger *slog.Logger) *bus {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &bus{ctx: ctx, cancel: cancel, logger: logger}
+}
+
+func (b *bus) subscribe(handler Handler, opts SubscribeOptions) *Subscription {
+	s := &Subscription{
+		bus:      b,
+		handler:  handler,
+		filter:   opts.Filter,
+		overflow: opts.Overflow,
+		queue:    make(chan Event, cmp.Or(opts.Buffer, defaultEventBuffer)),
+	}
+	s.ctx, s.cancel = context.WithCancel(b.ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ctx.Err() != nil {
+		// Closed, so the subscription is stopped from the start
+		return s
+	}
+	b.subscriptions = append(b.subscriptions, s)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		s.run()
+	}()
+	return s
+}
+
+// publish queues e for every subscription it matches, applying their overflow rules. It only fails if ctx is done
+// while waiting for a Block subscription.
+func (b *bus) publish(ctx context.Context, e Event) error {
+	b.mu.Lock()
+	subscriptions := slices.Clone(b.subscriptions)
+	b.mu.Unlock()
+
+	for _, s := range subscriptions {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		switch s.overflow {
+		case DropNewest:
+			select {
+			case s.queue <- e:
+			default:
+				b.logger.WarnContext(ctx, "dropping event for a slow subscriber", "type", e.Type, "room", e.Room)
+			}
+		case DropOldest:
+			for queued := false; !queued; {
+				select {
+				case s.queue <- e:
+					queued = true
+				default:
+					select {
+					case old := <-s.queue:
+						b.logger.WarnContext(ctx, "dropping event for a slow subscriber", "type", old.Type, "room", old.Room)
+					default:
+					}
+				}
+			}
+		default:
+			select {
+			case s.queue <- e:
+				continue
+			default:
+			}
+			select {
+			case s.queue <- e:
+			case <-s.ctx.Done():
+			case <-ctx.Done():
+				return errors.WithStack(ctx.Err())
+			}
+		}
+	}
+	return nil
+}
+
+// close stops every subscription and waits for their handlers to return
+func (b *bus) close() {
+	b.mu.Lock()
+	b.cancel()
+	b.subscriptions = nil
+	b.mu.Unlock()
+	b.wg.Wait()
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_Subscribe(t *testing.T) {
+	ls := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "getassertion", r.URL.Query().Get("act"))
+		_, err := w.Write([]byte("assertion-Bot"))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ls.Close)
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		require.NoError(t, err)
+		require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte("|challstr|4|abc")))
+		_, msg, err := c.Read(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, "|/trn Bot,0,assertion-Bot", string(msg))
+		require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte("|updateuser| Bot|1|1|{}")))
+		require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte(">battle-1\n|init|battle\n|title|A vs. B")))
+		require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte("|pm| Other| Bot|hi")))
+		for {
+			if _, _, err := c.Read(t.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(ws.Close)
+
+	session, err := NewSession(Config{
+		Address:         ws.URL,
+		LoginEndpoints:  []string{ls.URL + "/api/login"},
+		ActionEndpoints: []string{ls.URL + "/action.php"},
+		Timeout:         time.Second,
+		ShutdownTimeout: time.Second,
+	}, Credentials{Username: "Bot"}, slogt.New(t, slogt.JSON()))
+	require.NoError(t, err)
+
+	all := make(chan string, 10)
+	session.Subscribe(func(_ context.Context, e Event) {
+		all <- e.Room + "|" + e.Type
+	}, SubscribeOptions{})
+	roomsOnInit := make(chan []string, 1)
+	session.Subscribe(func(_ context.Context, e Event) {
+		roomsOnInit <- session.Rooms()
+	}, SubscribeOptions{Filter: AllOf(MessageTypes("init"), InRooms("battle-1"))})
+	pms := make(chan string, 1)
+	session.Subscribe(func(_ context.Context, e Event) {
+		pms <- e.Message.UnknownMessage.Data
+	}, SubscribeOptions{Filter: func(e Event) bool {
+		return e.Type == "pm" && strings.HasSuffix(e.Message.UnknownMessage.Data, "|hi")
+	}})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	runErr := make(chan error)
+	go func() {
+		runErr <- session.Run(ctx)
+	}()
+	select {
+	case data := <-pms:
+		require.Equal(t, " Other| Bot|hi", data)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for pm")
+	}
+	cancel()
+	require.NoError(t, <-runErr)
+
+	// Handlers have returned once Run has
+	close(all)
+	var events []string
+	for e := range all {
+		events = append(events, e)
+	}
+	require.Equal(t, []string{"|challstr", "|updateuser", "battle-1|init", "battle-1|title", "|pm"}, events)
+	require.Equal(t, []string{"battle-1"}, <-roomsOnInit)
+
+	stats := session.Stats()
+	require.Equal(t, int64(1), stats.MessagesSent)
+	require.Equal(t, int64(len("|/trn Bot,0,assertion-Bot")), stats.PayloadWritten)
+	require.Equal(t, int64(len("|challstr|4|abc")+len("|updateuser| Bot|1|1|{}")+len(">battle-1\n|init|battle\n|title|A vs. B")+len("|pm| Other| Bot|hi")), stats.PayloadRead)
+	require.Greater(t, stats.WireRead, stats.PayloadRead)
+}
+
+func TestBus(t *testing.T) {
+	// subscribe returns a subscription whose handler blocks on its first event until release is closed
+	subscribe := func(t *testing.T, b *bus, opts SubscribeOptions) (sub *Subscription, got <-chan string, release chan struct{}) {
+		t.Helper()
+		gotCh := make(chan string, 10)
+		release = make(chan struct{})
+		sub = b.subscribe(func(ctx context.Context, e Event) {
+			gotCh <- e.Type
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+		}, opts)
+		return sub, gotCh, release
+	}
+	receive := func(t *testing.T, got <-chan string) string {
+		t.Helper()
+		select {
+		case e := <-got:
+			return e
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for event")
+			return ""
+		}
+	}
+
+	for _, tt := range []struct {
+		overflow Overflow
+		want     []string
+	}{
+		{overflow: DropNewest, want: []string{"1", "2"}},
+		{overflow: DropOldest, want: []string{"1", "3"}},
+	} {
+		t.Run(fmt.Sprintf("overflow %d", tt.overflow), func(t *testing.T) {
+			b := newBus(slogt.New(t))
+			t.Cleanup(b.close)
+			_, got, release := subscribe(t, b, SubscribeOptions{Buffer: 1, Overflow: tt.overflow})
+			require.NoError(t, b.publish(t.Context(), Event{Type: "1"}))
+			require.Equal(t, "1", receive(t, got))
+			// The handler is busy with 1, so only one of 2 and 3 fits in the buffer
+			require.NoError(t, b.publish(t.Context(), Event{Type: "2"}))
+			require.NoError(t, b.publish(t.Context(), Event{Type: "3"}))
+			close(release)
+			require.Equal(t, tt.want[1], receive(t, got))
+			require.Empty(t, got)
+		})
+	}
+
+	t.Run("block", func(t *testing.T) {
+		b := newBus(slogt.New(t))
+		t.Cleanup(b.close)
+		_, got, release := subscribe(t, b, SubscribeOptions{Buffer: 1})
+		other := make(chan string, 10)
+		b.subscribe(func(_ context.Context, e Event) {
+			other <- e.Type
+		}, SubscribeOptions{Filter: MessageTypes("2", "3")})
+
+		require.NoError(t, b.publish(t.Context(), Event{Type: "1"}))
+		require.Equal(t, "1", receive(t, got))
+		require.NoError(t, b.publish(t.Context(), Event{Type: "2"}))
+		published := make(chan error)
+		go func() {
+			published <- b.publish(t.Context(), Event{Type: "3"})
+		}()
+		select {
+		case <-published:
+			require.FailNow(t, "published to a full subscription")
+		case <-time.After(50 * time.Millisecond):
+		}
+		// Later subscriptions wait too
+		require.Equal(t, "2", receive(t, other))
+		require.Empty(t, other)
+
+		close(release)
+		require.NoError(t, <-published)
+		require.Equal(t, "2", receive(t, got))
+		require.Equal(t, "3", receive(t, got))
+		require.Equal(t, "3", receive(t, other))
+
+		// Publishing gives up once its context is done
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		_, got, _ = subscribe(t, b, SubscribeOptions{Buffer: 1, Filter: MessageTypes("4")})
+		require.NoError(t, b.publish(ctx, Event{Type: "4"}))
+		require.Equal(t, "4", receive(t, got))
+		require.NoError(t, b.publish(ctx, Event{Type: "4"}))
+		require.ErrorIs(t, b.publish(ctx, Event{Type: "4"}), context.Canceled)
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		b := newBus(slogt.New(t))
+		t.Cleanup(b.close)
+		sub, got, _ := subscribe(t, b, SubscribeOptions{})
+		require.NoError(t, b.publish(t.Context(), Event{Type: "1"}))
+		require.Equal(t, "1", receive(t, got))
+		require.NoError(t, b.publish(t.Context(), Event{Type: "2"}))
+
+		// The running handler is cancelled, and the queued event is never handled
+		sub.Unsubscribe()
+		require.NoError(t, b.publish(t.Context(), Event{Type: "3"}))
+		b.close()
+		require.Empty(t, got)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		b := newBus(slogt.New(t))
+		b.close()
+		sub := b.subscribe(func(context.Context, Event) {
+			require.Fail(t, "handled event after closing")
+		}, SubscribeOptions{})
+		require.Error(t, sub.ctx.Err())
+		require.NoError(t, b.publish(t.Context(), Event{Type: "1"}))
+		sub.Unsubscribe()
+	})
+}
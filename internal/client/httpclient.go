@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// newHTTPClient returns the client shared by SockJS and login, configured from c. It has no timeout of its
+// own, requests are bound by their contexts instead.
+func (c *Config) newHTTPClient() (*http.Client, error) {
+	transport, err := c.newHTTPTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: c.withHeaders(transport)}, nil
+}
+
+// newWebsocketClient is like newHTTPClient, but counts the bytes going over the network in counts. Websocket dials
+// reject clients with timeouts, as a connection outlives its handshake.
+func (c *Config) newWebsocketClient(counts *byteCounts) (*http.Client, error) {
+	transport, err := c.newHTTPTransport()
+	if err != nil {
+		return nil, err
+	}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn, counts: counts}, nil
+	}
+	return &http.Client{Transport: c.withHeaders(transport)}, nil
+}
+
+func (c *Config) newHTTPTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid proxy URL %q", c.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CABundle != "" {
+		bundle, err := os.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CA bundle")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, errors.Errorf("no certificates found in CA bundle %s", c.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, errors.New("client cert and client key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// withHeaders wraps rt to set the extra headers from c
+func (c *Config) withHeaders(rt http.RoundTripper) http.RoundTripper {
+	if len(c.Header) == 0 {
+		return rt
+	}
+	header := http.Header{}
+	for k, v := range c.Header {
+		header.Set(k, v)
+	}
+	return &headerRoundTripper{header: header, next: rt}
+}
+
+// headerRoundTripper sets extra headers on every request, e.g. a User-Agent or auth header
+type headerRoundTripper struct {
+	header http.Header
+	next   http.RoundTripper
+}
+
+func (h *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Round trippers mustn't modify the request they're given
+	req = req.Clone(req.Context())
+	for k, v := range h.header {
+		req.Header[k] = v
+	}
+	return h.next.RoundTrip(req)
+}
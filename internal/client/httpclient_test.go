@@ -0,0 +1,59 @@
+package client
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLI_newHTTPClient(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "gholden", r.Header.Get("User-Agent"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(ts.Close)
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: ts.Certificate().Raw,
+	}), 0o600))
+
+	// The test server's certificate is only trusted with the bundle
+	untrusted, err := (&Config{}).newHTTPClient()
+	require.NoError(t, err)
+	_, err = untrusted.Get(ts.URL)
+	require.Error(t, err)
+
+	c := &Config{
+		CABundle: bundle,
+		Header:   map[string]string{"User-Agent": "gholden"},
+	}
+	client, err := c.newHTTPClient()
+	require.NoError(t, err)
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Plain HTTP requests are sent to the proxy with the full URL
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(proxy.Close)
+	client, err = (&Config{Proxy: proxy.URL}).newHTTPClient()
+	require.NoError(t, err)
+	resp, err = client.Get("http://showdown.invalid/api/login")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "http://showdown.invalid/api/login", <-proxied)
+
+	_, err = (&Config{ClientCert: bundle}).newHTTPClient()
+	require.EqualError(t, err, "client cert and client key must be set together")
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errConnectionStale is returned when the server stops responding on a connection that still looks open, e.g. a
+// half-open TCP connection after the server went away without closing it
+var errConnectionStale = errors.New("connection stale")
+
+// keepalive pings the server every interval until ctx is done, returning errConnectionStale if a ping isn't answered
+// within timeout and notifying activity when one is. Pongs are only read while something else is reading from the
+// connection, i.e. the subscriber.
+func keepalive(ctx context.Context, p pinger, activity chan<- struct{}, interval, timeout time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := p.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return errors.WithStack(ctx.Err())
+			}
+			return errors.WithMessagef(errConnectionStale, "ping failed: %s", err)
+		}
+		notify(activity)
+	}
+}
+
+// notify does a non-blocking send on ch, which should have a buffer of 1 so that at least one notification is
+// always pending after a burst
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLI_Stale(t *testing.T) {
+	doneCh := make(chan struct{})
+	helper := &loginHelper{
+		doneCh:  doneCh,
+		loginCh: make(chan loginInfo),
+	}
+	ls := httptest.NewServer(helper.loginServer(t))
+	t.Cleanup(ls.Close)
+	ws := httptest.NewServer(helper.websocketStale(t))
+	t.Cleanup(ws.Close)
+
+	stdin, stdinWriter := io.Pipe()
+	t.Cleanup(func() {
+		if err := stdinWriter.Close(); err != nil {
+			t.Log("error closing stdinWriter", err)
+		}
+	})
+	c := &CLI{
+		Config: Config{
+			Address:               ws.URL,
+			LoginEndpoints:        []string{ls.URL},
+			ActionEndpoints:       []string{ls.URL},
+			Timeout:               time.Second,
+			ReconnectInitialDelay: time.Millisecond,
+			ReconnectMaxDelay:     10 * time.Millisecond,
+			ReconnectMaxAttempts:  3,
+			KeepaliveInterval:     50 * time.Millisecond,
+			ReadIdleTimeout:       200 * time.Millisecond,
+		},
+		Logger: slogt.New(t, slogt.JSON()),
+		Stdin:  stdin,
+		Stdout: io.Discard,
+	}
+	runInBackground(t, c.Run)
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for login after the connection went stale")
+	}
+}
+
+// websocketStale stops reading after the first login, so pings go unanswered like on a half-open connection, and
+// expects the client to reconnect and log in again
+func (h *loginHelper) websocketStale(t *testing.T) http.HandlerFunc {
+	t.Helper()
+	var count atomic.Int32
+	return h.websocketServer(t, nil, nil, func(c *websocket.Conn, _ loginInfo) {
+		if count.Add(1) == 1 {
+			<-t.Context().Done()
+			_ = c.CloseNow()
+			return
+		}
+		close(h.doneCh)
+	})
+}
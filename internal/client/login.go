@@ -0,0 +1,368 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gholden-go/internal/grammar"
+
+	"github.com/pkg/errors"
+)
+
+// Typed login errors, returned wrapped with the server's message if it sent one. All but ErrLoginUnavailable are
+// permanent, logging in again won't help until the credentials or the account change.
+var (
+	// ErrNameRegistered means the name belongs to a registered account, so logging in needs its password
+	ErrNameRegistered = errors.New("name is registered, password required")
+	// ErrLoginFailed means the login server rejected the login, e.g. because of a wrong password
+	ErrLoginFailed = errors.New("login failed")
+	// ErrAccountLocked means the account has been locked or banned
+	ErrAccountLocked = errors.New("account locked")
+	// ErrNotLoggedIn means the login server answered without logging us in
+	ErrNotLoggedIn = errors.New("not logged in")
+	// ErrNameTaken means the server refused to let us use the name we logged in with, e.g. because someone else is
+	// using it
+	ErrNameTaken = errors.New("name taken")
+	// ErrLoginUnavailable means every login endpoint kept failing with network or server errors, so logging in may
+	// work later
+	ErrLoginUnavailable = errors.New("login server unavailable")
+	// ErrLoginRejected means every login endpoint refused the request itself, e.g. with a 404 because the endpoints
+	// are misconfigured
+	ErrLoginRejected = errors.New("login request rejected")
+)
+
+// IsPermanentLoginError returns whether err means logging in will keep failing, rather than that the login server is
+// unavailable or something unrelated to logging in went wrong
+func IsPermanentLoginError(err error) bool {
+	for _, target := range []error{
+		ErrNameRegistered, ErrLoginFailed, ErrAccountLocked, ErrNotLoggedIn, ErrNameTaken, ErrLoginRejected,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// errSessionExpired means there's no sid logged in to the account, so we have to log in from scratch
+var errSessionExpired = errors.New("login session expired")
+
+type loginInput struct {
+	Name     string // required
+	Pass     string // empty to get an assertion for an unregistered name
+	Challstr string // required
+}
+
+// form returns the form the login endpoint expects, name=USERNAME&pass=PASSWORD&challstr=CHALLSTR
+func (l loginInput) form() url.Values {
+	return url.Values{
+		"name":     {l.Name},
+		"pass":     {l.Pass},
+		"challstr": {l.Challstr},
+	}
+}
+
+type loginResponse struct {
+	ActionSuccess bool `json:"actionsuccess"`
+	// Assertion is false instead of a string when there's no assertion to be had
+	Assertion json.RawMessage `json:"assertion"`
+	CurUser   *struct {
+		LoggedIn bool   `json:"loggedin"`
+		Username string `json:"username"`
+	} `json:"curuser"`
+}
+
+// checkAssertion returns a typed error for the assertions the server sends instead of an error: `;` if the name is
+// registered, or `;;` followed by a message, e.g. `;;Your username is registered, enter its password`
+func checkAssertion(assertion string) error {
+	switch {
+	case assertion == ";":
+		return errors.WithStack(ErrNameRegistered)
+	case strings.HasPrefix(assertion, ";;"):
+		msg := strings.TrimPrefix(assertion, ";;")
+		if strings.Contains(strings.ToLower(msg), "locked") {
+			return errors.WithMessage(ErrAccountLocked, msg)
+		}
+		return errors.WithMessage(ErrLoginFailed, msg)
+	case assertion == "":
+		return errors.New("no assertion")
+	}
+	return nil
+}
+
+// parseLoginResponse returns the assertion in a login response, or a typed error if the login didn't go through
+func parseLoginResponse(b []byte) (string, error) {
+	var l loginResponse
+	// Body is prefixed by a `]` character, per the docs https://github.com/smogon/pokemon-showdown/blob/master/PROTOCOL.md
+	if err := json.Unmarshal(bytes.TrimPrefix(b, []byte("]")), &l); err != nil {
+		return "", errors.Wrap(err, "failed to unmarshal login response")
+	}
+	var assertion string
+	if err := json.Unmarshal(l.Assertion, &assertion); err != nil {
+		// Most likely `false`, which comes with actionsuccess:false
+		assertion = ""
+	}
+	switch {
+	case assertion == ";" || strings.HasPrefix(assertion, ";;"):
+		return "", checkAssertion(assertion)
+	case !l.ActionSuccess:
+		return "", errors.WithStack(ErrLoginFailed)
+	case l.CurUser == nil || !l.CurUser.LoggedIn:
+		return "", errors.WithStack(ErrNotLoggedIn)
+	case assertion == "":
+		return "", errors.New("login response has no assertion")
+	}
+	return assertion, nil
+}
+
+type upkeepResponse struct {
+	LoggedIn  bool   `json:"loggedin"`
+	Username  string `json:"username"`
+	Assertion string `json:"assertion"`
+}
+
+// parseUpkeepResponse returns the assertion in an upkeep response, or errSessionExpired if the sid is no longer logged
+// in as name
+func parseUpkeepResponse(b []byte, name string) (string, error) {
+	var u upkeepResponse
+	// Prefixed by `]` like login responses
+	if err := json.Unmarshal(bytes.TrimPrefix(b, []byte("]")), &u); err != nil {
+		return "", errors.Wrap(err, "failed to unmarshal upkeep response")
+	}
+	if !u.LoggedIn || grammar.ToID(u.Username) != grammar.ToID(name) {
+		return "", errors.WithMessagef(errSessionExpired, "logged in as %q", u.Username)
+	}
+	if err := checkAssertion(u.Assertion); err != nil {
+		return "", err
+	}
+	return u.Assertion, nil
+}
+
+// parseAssertionResponse returns the assertion in a getassertion response, which is the assertion itself
+func parseAssertionResponse(b []byte) (string, error) {
+	assertion := strings.TrimSpace(string(b))
+	if err := checkAssertion(assertion); err != nil {
+		return "", err
+	}
+	return assertion, nil
+}
+
+// login logs in to Showdown following the guidance in the protocol documentation:
+// https://github.com/smogon/pokemon-showdown/blob/master/PROTOCOL.md
+// It keeps using the login session from an earlier login while it lasts, and otherwise logs in with the login action
+// if we have a password and the getassertion action if we don't.
+func (c *controller) login(ctx context.Context, input loginInput) error {
+	c.logger.DebugContext(ctx, "Sending login request", "username", input.Name)
+	assertion, err := c.upkeep(ctx, input)
+	if errors.Is(err, errSessionExpired) {
+		if input.Pass == "" {
+			assertion, err = c.getAssertion(ctx, input)
+		} else {
+			assertion, err = c.loginWithPassword(ctx, input)
+		}
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "failed to log in as %s", input.Name)
+	}
+
+	// From docs:
+	// Finish logging in (or renaming) by sending:
+	// /trn USERNAME,0,ASSERTION where USERNAME is your desired username and ASSERTION is data.assertion
+	err = c.send(ctx, grammar.Rename{
+		Username:  input.Name,
+		Assertion: assertion,
+	})
+	if err != nil {
+		return errors.WithMessage(err, "failed to send login command to socket")
+	}
+	c.logger.DebugContext(ctx, "sent login command to socket")
+	return nil
+}
+
+// upkeep gets an assertion for the account the sid is logged in to, returning errSessionExpired without a sid or if
+// it has expired
+func (c *controller) upkeep(ctx context.Context, input loginInput) (string, error) {
+	host, sid := c.sid.get()
+	if sid == "" {
+		return "", errors.WithStack(errSessionExpired)
+	}
+	// Only the host that issued the sid knows about it
+	var endpoints []string
+	for _, endpoint := range c.actionEndpoints {
+		if endpointHost(endpoint) == host {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if len(endpoints) == 0 {
+		return "", errors.WithMessagef(errSessionExpired, "no action endpoint on %s", host)
+	}
+	b, err := c.doLoginRequest(ctx, endpoints, func(ctx context.Context, endpoint string) (*http.Request, error) {
+		body := strings.NewReader(url.Values{"act": {"upkeep"}, "challstr": {input.Challstr}}.Encode())
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create upkeep request")
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	assertion, err := parseUpkeepResponse(b, input.Name)
+	if errors.Is(err, errSessionExpired) {
+		c.logger.InfoContext(ctx, "login session expired, logging in again", "error", err)
+		if err := c.sid.set("", ""); err != nil {
+			c.logger.WarnContext(ctx, "failed to remove expired login session", "error", err)
+		}
+	}
+	return assertion, err
+}
+
+// loginWithPassword gets an assertion for a registered name
+func (c *controller) loginWithPassword(ctx context.Context, input loginInput) (string, error) {
+	b, err := c.doLoginRequest(ctx, c.loginEndpoints, func(ctx context.Context, endpoint string) (*http.Request, error) {
+		// From docs:
+		// you'll need to make an HTTP POST request to https://play.pokemonshowdown.com/api/login with the data
+		// name=USERNAME&pass=PASSWORD&challstr=CHALLSTR
+		// USERNAME is your username and PASSWORD is your password, and CHALLSTR is the value you got from |challstr|.
+		// Note that CHALLSTR contains | characters.
+		body := strings.NewReader(input.form().Encode())
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create login request")
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return parseLoginResponse(b)
+}
+
+// getAssertion gets an assertion for an unregistered name, which needs no password
+func (c *controller) getAssertion(ctx context.Context, input loginInput) (string, error) {
+	b, err := c.doLoginRequest(ctx, c.actionEndpoints, func(ctx context.Context, endpoint string) (*http.Request, error) {
+		// Unregistered names only need act=getassertion&userid=USERID&challstr=CHALLSTR, and the response is the
+		// assertion itself instead of JSON
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid action endpoint %q", endpoint)
+		}
+		u.RawQuery = url.Values{
+			"act":      {"getassertion"},
+			"userid":   {grammar.ToID(input.Name)},
+			"challstr": {input.Challstr},
+		}.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create getassertion request")
+		}
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return parseAssertionResponse(b)
+}
+
+// doLoginRequest sends the request made by newRequest to each endpoint in turn until one of them answers, returning
+// the response body. When they all fail and at least one of them with a network or server error, it starts over with
+// backoff, and eventually gives up with ErrLoginUnavailable. When they all refuse the request, e.g. with a 404, it
+// gives up with ErrLoginRejected right away.
+func (c *controller) doLoginRequest(
+	ctx context.Context,
+	endpoints []string,
+	newRequest func(ctx context.Context, endpoint string) (*http.Request, error),
+) ([]byte, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no login endpoints configured")
+	}
+	b := c.loginBackoff()
+	for {
+		var lastErr error
+		rejected := true
+		for _, endpoint := range endpoints {
+			body, transient, err := c.tryLoginRequest(ctx, endpoint, newRequest)
+			if err == nil {
+				return body, nil
+			}
+			if ctx.Err() != nil {
+				return nil, errors.WithStack(ctx.Err())
+			}
+			c.logger.WarnContext(ctx, "login endpoint failed", "endpoint", endpoint, "error", err)
+			lastErr = err
+			rejected = rejected && !transient
+		}
+		if rejected {
+			return nil, errors.WithMessage(ErrLoginRejected, lastErr.Error())
+		}
+
+		delay, backoffErr := b.next()
+		if backoffErr != nil {
+			return nil, errors.WithMessagef(ErrLoginUnavailable, "%s: %s", backoffErr, lastErr)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, errors.WithStack(ctx.Err())
+		}
+	}
+}
+
+// tryLoginRequest sends a request to the login server, with our sid if it issued it, returning the response body if
+// it succeeded, or whether the error is transient, i.e. a network or server error that may go away if we try again.
+// The sid is replaced by any new one the server sets.
+func (c *controller) tryLoginRequest(
+	ctx context.Context,
+	endpoint string,
+	newRequest func(ctx context.Context, endpoint string) (*http.Request, error),
+) ([]byte, bool, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := newRequest(reqCtx, endpoint)
+	if err != nil {
+		return nil, false, err
+	}
+	if sid := c.sid.sidFor(endpoint); sid != "" {
+		req.AddCookie(&http.Cookie{Name: "sid", Value: sid})
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, true, errors.Wrap(err, "failed to send login request")
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			c.logger.ErrorContext(context.Background(), "failed to close response body", "error", errors.WithStack(err))
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		transient := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, transient, errors.Errorf("login request failed with status %s", resp.Status)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name != "sid" {
+			continue
+		}
+		sid := cookie.Value
+		if cookie.MaxAge < 0 {
+			sid = ""
+		}
+		if err := c.sid.set(endpointHost(endpoint), sid); err != nil {
+			c.logger.WarnContext(ctx, "failed to save login session", "error", err)
+		}
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, errors.Wrap(err, "failed to read response body")
+	}
+	// The body isn't logged as it's the assertion itself for getassertion requests
+	c.logger.DebugContext(ctx, "response from login", "code", resp.StatusCode, "size", len(b))
+	return b, false, nil
+}
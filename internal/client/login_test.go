@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoginResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		assertion string
+		err       error
+	}{
+		{
+			name:      "logged in",
+			body:      `]{"actionsuccess":true,"assertion":"abc,name,2,1766374653","curuser":{"loggedin":true,"username":"Name","userid":"name"}}`,
+			assertion: "abc,name,2,1766374653",
+		},
+		{
+			name: "registered",
+			body: `]{"actionsuccess":true,"assertion":";","curuser":{"loggedin":false}}`,
+			err:  ErrNameRegistered,
+		},
+		{
+			name: "action failed",
+			body: `]{"actionsuccess":false,"assertion":false}`,
+			err:  ErrLoginFailed,
+		},
+		{
+			name: "wrong password",
+			body: `]{"actionsuccess":true,"assertion":";;Wrong password.","curuser":{"loggedin":false}}`,
+			err:  ErrLoginFailed,
+		},
+		{
+			name: "not logged in",
+			body: `]{"actionsuccess":true,"assertion":"abc,name,2,1766374653","curuser":{"loggedin":false}}`,
+			err:  ErrNotLoggedIn,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertion, err := parseLoginResponse([]byte(tt.body))
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.assertion, assertion)
+		})
+	}
+}
+
+func TestController_doLoginRequest(t *testing.T) {
+	var requests atomic.Int32
+	failures := 2
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(requests.Add(1)) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, err := w.Write([]byte("ok"))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(flaky.Close)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(notFound.Close)
+
+	sid, err := loadSIDStore("")
+	require.NoError(t, err)
+	c := &controller{
+		httpClient: http.DefaultClient,
+		timeout:    time.Second,
+		sid:        sid,
+		loginBackoff: func() *backoff {
+			return newBackoff(time.Millisecond, time.Millisecond, 2, 0)
+		},
+		logger: slogt.New(t),
+	}
+	newRequest := func(ctx context.Context, endpoint string) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	}
+
+	// Endpoints that are down or failing are skipped, and the whole list retried until one of them answers
+	b, err := c.doLoginRequest(t.Context(), []string{down.URL, flaky.URL}, newRequest)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(b))
+	require.EqualValues(t, 3, requests.Load())
+
+	// Endpoints refusing the request are skipped too
+	requests.Store(0)
+	failures = 0
+	b, err = c.doLoginRequest(t.Context(), []string{notFound.URL, flaky.URL}, newRequest)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(b))
+	require.EqualValues(t, 1, requests.Load())
+
+	// Refusals aren't retried once every endpoint has refused
+	_, err = c.doLoginRequest(t.Context(), []string{notFound.URL, notFound.URL}, newRequest)
+	require.EqualError(t, err, "login request failed with status 404 Not Found: login request rejected")
+	require.ErrorIs(t, err, ErrLoginRejected)
+	require.True(t, IsPermanentLoginError(err))
+
+	requests.Store(0)
+	failures = 100
+	_, err = c.doLoginRequest(t.Context(), []string{down.URL, flaky.URL}, newRequest)
+	require.ErrorIs(t, err, ErrLoginUnavailable)
+	require.False(t, IsPermanentLoginError(err))
+	require.EqualValues(t, 3, requests.Load())
+}
+
+func TestSession_PermanentLoginError(t *testing.T) {
+	var logins atomic.Int32
+	ls := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logins.Add(1)
+		_, err := w.Write([]byte(`]{"actionsuccess":true,"assertion":";;Your account is locked.","curuser":{"loggedin":false}}`))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ls.Close)
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		require.NoError(t, err)
+		defer c.CloseNow()
+		require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte("|challstr|4|abc")))
+		// Wait for the client to give up
+		_, _, err = c.Read(t.Context())
+		require.Error(t, err)
+	}))
+	t.Cleanup(ws.Close)
+
+	session, err := NewSession(Config{
+		Address:               ws.URL,
+		LoginEndpoints:        []string{ls.URL},
+		Timeout:               time.Second,
+		ReconnectInitialDelay: time.Millisecond,
+		ReconnectMaxDelay:     time.Millisecond,
+	}, Credentials{Username: "Bot", Password: "hunter2"}, slogt.New(t, slogt.JSON()))
+	require.NoError(t, err)
+
+	// Reconnecting won't unlock the account, so the session gives up right away
+	err = session.Run(t.Context())
+	require.ErrorIs(t, err, ErrAccountLocked)
+	require.True(t, IsPermanentLoginError(err))
+	require.EqualValues(t, 1, logins.Load())
+}
+
+func TestParseAssertionResponse(t *testing.T) {
+	assertion, err := parseAssertionResponse([]byte("abc,testname,1,1766374653\n"))
+	require.NoError(t, err)
+	require.Equal(t, "abc,testname,1,1766374653", assertion)
+
+	_, err = parseAssertionResponse([]byte(";"))
+	require.ErrorIs(t, err, ErrNameRegistered)
+	_, err = parseAssertionResponse([]byte(";;Your username is registered, a password is required."))
+	require.ErrorIs(t, err, ErrLoginFailed)
+	require.ErrorContains(t, err, "a password is required")
+	_, err = parseAssertionResponse(nil)
+	require.Error(t, err)
+}
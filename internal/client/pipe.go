@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Pipe returns the two ends of an in-memory connection, e.g. to run the client against a fake server. Frames written
+// to one end are read from the other, and each write blocks until the frame has been read. Closing either end closes
+// both.
+func Pipe() (Transport, Transport) {
+	a, b := make(chan []byte), make(chan []byte)
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	return &pipeEnd{in: a, out: b, closed: closed, once: once}, &pipeEnd{in: b, out: a, closed: closed, once: once}
+}
+
+type pipeEnd struct {
+	in     <-chan []byte
+	out    chan<- []byte
+	closed chan struct{}
+	once   *sync.Once
+}
+
+func (p *pipeEnd) ReadFrame(ctx context.Context) ([]byte, error) {
+	select {
+	case frame := <-p.in:
+		return frame, nil
+	case <-p.closed:
+		return nil, errors.WithStack(net.ErrClosed)
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+func (p *pipeEnd) WriteFrame(ctx context.Context, frame []byte) error {
+	// Copy the frame, so the caller can reuse it once the write returns
+	frame = append([]byte(nil), frame...)
+	select {
+	case p.out <- frame:
+		return nil
+	case <-p.closed:
+		return errors.WithStack(net.ErrClosed)
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+func (p *pipeEnd) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
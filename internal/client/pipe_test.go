@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLI_Pipe(t *testing.T) {
+	helper := &loginHelper{
+		doneCh:  make(chan struct{}),
+		loginCh: make(chan loginInfo),
+	}
+	ls := httptest.NewServer(helper.loginServer(t))
+	t.Cleanup(ls.Close)
+
+	client, server := Pipe()
+	stdin, stdinWriter := io.Pipe()
+	t.Cleanup(func() {
+		if err := stdinWriter.Close(); err != nil {
+			t.Log("error closing stdinWriter", err)
+		}
+	})
+	ctx, cancel := context.WithCancel(t.Context())
+	c := &CLI{
+		Config: Config{
+			Dial: func(context.Context) (Transport, error) {
+				return client, nil
+			},
+			LoginEndpoints:  []string{ls.URL},
+			ActionEndpoints: []string{ls.URL},
+			Timeout:         time.Second,
+			ShutdownTimeout: time.Second,
+			ShutdownPolicy:  string(shutdownLeave),
+		},
+		Logger: slogt.New(t, slogt.JSON()),
+		Stdin:  stdin,
+		Stdout: io.Discard,
+	}
+	runErr := make(chan error)
+	go func() {
+		runErr <- c.Run(ctx)
+	}()
+
+	write := func(frame string) {
+		require.NoError(t, server.WriteFrame(t.Context(), []byte(frame)))
+	}
+	read := func() string {
+		frame, err := server.ReadFrame(t.Context())
+		require.NoError(t, err)
+		return string(frame)
+	}
+	write(">lobby\n|init|chat")
+	write("|challstr|4|abc")
+	info := <-helper.loginCh
+	require.Equal(t, fmt.Sprintf(`|/trn %s,0,%s`, info.username, info.assertion), read())
+	write(fmt.Sprintf("|updateuser| %s|1|1|{}", info.username))
+
+	// Input is only forwarded once logged in
+	_, err := io.WriteString(stdinWriter, "|/join lobby\n")
+	require.NoError(t, err)
+	require.Equal(t, "|/join lobby", read())
+
+	cancel()
+	require.Equal(t, "|/leave lobby", read())
+	_, err = server.ReadFrame(t.Context())
+	require.ErrorIs(t, err, net.ErrClosed)
+	require.NoError(t, <-runErr)
+}
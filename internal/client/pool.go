@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	stderrors "errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	errPoolRunning = errors.New("pool is already running")
+	errPoolStopped = errors.New("pool has stopped")
+)
+
+// Pool runs many sessions from one process, e.g. one per bot account. Every session shares the pool's config and
+// logger, but logs in with its own credentials.
+type Pool struct {
+	config Config
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*poolSession
+	// ctx is set once Run is called, so that sessions added while running start right away
+	ctx context.Context
+	// stopped is set once ctx is done, after which no more sessions are started
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// poolSession is a session along with what's needed to stop it on its own
+type poolSession struct {
+	session *Session
+	cancel  context.CancelFunc
+	// done is closed once the session has stopped, after setting err
+	done chan struct{}
+	err  error
+}
+
+func NewPool(config Config, logger *slog.Logger) *Pool {
+	return &Pool{
+		config:   config,
+		logger:   logger,
+		sessions: make(map[string]*poolSession),
+	}
+}
+
+// Add creates a session for credentials, starting it right away if the pool is running. Usernames must be unique
+// within the pool.
+func (p *Pool) Add(credentials Credentials) (*Session, error) {
+	session, err := NewSession(p.config, credentials, p.logger)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return nil, errors.WithStack(errPoolStopped)
+	}
+	if _, ok := p.sessions[session.Username()]; ok {
+		return nil, errors.Errorf("session %s already exists", session.Username())
+	}
+	ps := &poolSession{session: session, done: make(chan struct{})}
+	p.sessions[session.Username()] = ps
+	if p.ctx != nil {
+		p.start(ps)
+	}
+	return session, nil
+}
+
+// Remove shuts down the session with the given username, if it's running, and removes it from the pool. It returns
+// the error the session stopped with.
+func (p *Pool) Remove(username string) error {
+	p.mu.Lock()
+	ps, ok := p.sessions[username]
+	delete(p.sessions, username)
+	p.mu.Unlock()
+	if !ok {
+		return errors.Errorf("no session %s", username)
+	}
+	if ps.cancel == nil {
+		return nil
+	}
+	ps.cancel()
+	<-ps.done
+	return ps.err
+}
+
+// Sessions returns every session in the pool, sorted by username
+func (p *Pool) Sessions() []*Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sessions := make([]*Session, 0, len(p.sessions))
+	for _, ps := range p.sessions {
+		sessions = append(sessions, ps.session)
+	}
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return strings.Compare(a.Username(), b.Username())
+	})
+	return sessions
+}
+
+// Run starts every session and blocks until ctx is done and they've all shut down. A session that stops early, e.g.
+// after running out of reconnect attempts, doesn't affect the others. Run returns the errors of every session still
+// in the pool that stopped with one.
+func (p *Pool) Run(ctx context.Context) error {
+	p.mu.Lock()
+	if p.ctx != nil {
+		p.mu.Unlock()
+		return errors.WithStack(errPoolRunning)
+	}
+	p.ctx = ctx
+	for _, ps := range p.sessions {
+		p.start(ps)
+	}
+	p.mu.Unlock()
+
+	<-ctx.Done()
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for username, ps := range p.sessions {
+		if ps.err != nil {
+			errs = append(errs, errors.WithMessagef(ps.err, "session %s", username))
+		}
+	}
+	return stderrors.Join(errs...)
+}
+
+// start runs ps until the pool's context is done or it's removed. p.mu must be held.
+func (p *Pool) start(ps *poolSession) {
+	ctx, cancel := context.WithCancel(p.ctx)
+	ps.cancel = cancel
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(ps.done)
+		defer cancel()
+		ps.err = ps.session.Run(ctx)
+		if ps.err != nil {
+			ps.session.logger.ErrorContext(ctx, "session stopped", "error", ps.err)
+		}
+	}()
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	ls := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "getassertion", r.URL.Query().Get("act"))
+		_, err := fmt.Fprintf(w, "assertion-%s", r.URL.Query().Get("userid"))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ls.Close)
+	loggedIn := make(chan string)
+	ws := httptest.NewServer(websocketPool(t, loggedIn))
+	t.Cleanup(ws.Close)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	pool := NewPool(Config{
+		Address:         ws.URL,
+		LoginEndpoints:  []string{ls.URL},
+		ActionEndpoints: []string{ls.URL},
+		Timeout:         time.Second,
+		ShutdownTimeout: time.Second,
+	}, slogt.New(t, slogt.JSON()))
+	_, err := pool.Add(Credentials{Username: "alice"})
+	require.NoError(t, err)
+	_, err = pool.Add(Credentials{Username: "alice"})
+	require.EqualError(t, err, "session alice already exists")
+	_, err = pool.Add(Credentials{Username: "bob"})
+	require.NoError(t, err)
+	runErr := make(chan error)
+	go func() {
+		runErr <- pool.Run(ctx)
+	}()
+
+	// Sessions log in independently, including ones added while the pool is running
+	expectLogins := func(expected ...string) {
+		t.Helper()
+		var usernames []string
+		for range expected {
+			select {
+			case username := <-loggedIn:
+				usernames = append(usernames, username)
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "timed out waiting for login")
+			}
+		}
+		require.ElementsMatch(t, expected, usernames)
+	}
+	expectLogins("alice", "bob")
+	carol, err := pool.Add(Credentials{Username: "carol"})
+	require.NoError(t, err)
+	expectLogins("carol")
+	<-carol.LoggedIn()
+
+	require.NoError(t, pool.Remove("bob"))
+	require.EqualError(t, pool.Remove("bob"), "no session bob")
+	var usernames []string
+	for _, session := range pool.Sessions() {
+		usernames = append(usernames, session.Username())
+	}
+	require.Equal(t, []string{"alice", "carol"}, usernames)
+
+	cancel()
+	select {
+	case err := <-runErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for pool to stop")
+	}
+	_, err = pool.Add(Credentials{Username: "dave"})
+	require.ErrorIs(t, err, errPoolStopped)
+}
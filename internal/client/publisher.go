@@ -4,39 +4,288 @@ import (
 	"context"
 	"gholden-go/internal/grammar"
 	"log/slog"
+	"sync"
 	"time"
 
-	"github.com/coder/websocket"
 	"github.com/pkg/errors"
 )
 
+// maxWriteAttempts is how many connections a message is tried on before giving up on it
+const maxWriteAttempts = 3
+
+// outgoing is a message queued for the publisher
+type outgoing struct {
+	msg grammar.ClientMessage
+	// result receives nil once msg has been written, or the error that stopped it from being written. It must have
+	// a buffer of 1.
+	result   chan error
+	attempts int
+}
+
+func newOutgoing(msg grammar.ClientMessage) *outgoing {
+	return &outgoing{msg: msg, result: make(chan error, 1)}
+}
+
+// retryable returns whether a message that failed to write can be retried on the next connection. Renames carry an
+// assertion for the challstr of the connection they were meant for, so the next connection needs a new login instead.
+func retryable(msg grammar.ClientMessage) bool {
+	_, rename := msg.(grammar.Rename)
+	return !rename
+}
+
 type publisher struct {
-	queue   <-chan grammar.ClientMessage
-	timeout time.Duration
-	logger  *slog.Logger
+	// logins are the only messages written before we've logged in on a connection, as the server would handle anything
+	// else as coming from a guest. Once logged in, they're still drained before the other queues.
+	logins <-chan *outgoing
+	queue  <-chan *outgoing
+	// priority is drained before queue once we've logged in, so that timed battle decisions are never stuck behind chat
+	priority <-chan *outgoing
+	limiter  *rateLimiter
+	counts   *byteCounts
+	timeout  time.Duration
+	logger   *slog.Logger
+	// flushes receives a channel to close once everything queued so far has been written
+	flushes chan chan struct{}
+	// pending failed to write on the previous connection, and is retried before anything else on the next one
+	pending *outgoing
+	mu      sync.Mutex
+	// stopped is closed once run returns, and nil while run isn't writing everything queued, i.e. while disconnected
+	// or logging in
+	stopped chan struct{}
+}
+
+type publisherOpts struct {
+	logins   <-chan *outgoing // required
+	queue    <-chan *outgoing // required
+	priority <-chan *outgoing // required
+	limiter  *rateLimiter     // required
+	counts   *byteCounts      // required
+	timeout  time.Duration    // required
+	logger   *slog.Logger     // required
 }
 
-func newPublisher(queue <-chan grammar.ClientMessage, timeout time.Duration, logger *slog.Logger) *publisher {
+func newPublisher(opts publisherOpts) *publisher {
 	return &publisher{
-		queue:   queue,
-		timeout: timeout,
-		logger:  logger,
+		logins:   opts.logins,
+		queue:    opts.queue,
+		priority: opts.priority,
+		limiter:  opts.limiter,
+		counts:   opts.counts,
+		timeout:  opts.timeout,
+		logger:   opts.logger,
+		flushes:  make(chan chan struct{}),
+	}
+}
+
+// isLogin returns whether msg goes on the login queue, which is all that's written until we've logged in
+func isLogin(msg grammar.ClientMessage) bool {
+	_, rename := msg.(grammar.Rename)
+	return rename
+}
+
+// isPriority returns whether msg goes on the priority queue, i.e. whether it's a battle decision, as those are timed
+func isPriority(msg grammar.ClientMessage) bool {
+	switch msg.(type) {
+	case grammar.Choose, grammar.TeamOrder, grammar.Undo:
+		return true
+	default:
+		return false
+	}
+}
+
+// run writes queued messages to the transport, as fast as the rate limiter allows. Until loggedIn is closed, only
+// logins are written, so that nothing is sent as a guest. Messages are only taken off the queues once they can be sent,
+// so a priority message only ever waits for the rate limit and the login. A failed write means the connection is
+// broken, so run returns and the message is retried on the next connection.
+func (p *publisher) run(ctx context.Context, t Transport, loggedIn <-chan struct{}) error {
+	if err := p.login(ctx, t, loggedIn); err != nil {
+		return err
+	}
+	if p.pending != nil {
+		if err := p.wait(ctx); err != nil {
+			return err
+		}
+		out := p.pending
+		p.pending = nil
+		p.logger.InfoContext(ctx, "retrying message", "message", out.msg, "attempt", out.attempts+1)
+		if err := p.write(ctx, t, out); err != nil {
+			return err
+		}
+	}
+	stopped := make(chan struct{})
+	p.setStopped(stopped)
+	defer func() {
+		p.setStopped(nil)
+		close(stopped)
+	}()
+	for {
+		if err := p.wait(ctx); err != nil {
+			return err
+		}
+		select {
+		case out := <-p.logins:
+			if err := p.write(ctx, t, out); err != nil {
+				return err
+			}
+			continue
+		case out := <-p.priority:
+			if err := p.write(ctx, t, out); err != nil {
+				return err
+			}
+			continue
+		default:
+		}
+		select {
+		case out := <-p.logins:
+			if err := p.write(ctx, t, out); err != nil {
+				return err
+			}
+		case out := <-p.priority:
+			if err := p.write(ctx, t, out); err != nil {
+				return err
+			}
+		case out := <-p.queue:
+			if err := p.write(ctx, t, out); err != nil {
+				return err
+			}
+		case done := <-p.flushes:
+			if err := p.drain(ctx, t); err != nil {
+				return err
+			}
+			close(done)
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
 	}
 }
 
-func (p *publisher) run(ctx context.Context, conn *websocket.Conn) error {
+// login writes logins until loggedIn is closed
+func (p *publisher) login(ctx context.Context, t Transport, loggedIn <-chan struct{}) error {
 	for {
 		select {
-		case msg := <-p.queue:
-			p.logger.DebugContext(ctx, "sending message", "message", msg)
-			writeCtx, cancel := context.WithTimeout(ctx, p.timeout)
-			err := conn.Write(writeCtx, websocket.MessageText, []byte(msg.Serialize()))
-			if err != nil {
-				p.logger.WarnContext(writeCtx, "failed to write serialized message", "error", errors.WithStack(err))
-			}
-			cancel()
+		case <-loggedIn:
+			return nil
+		default:
+		}
+		if err := p.wait(ctx); err != nil {
+			return err
+		}
+		select {
+		case out := <-p.logins:
+			if err := p.write(ctx, t, out); err != nil {
+				return err
+			}
+		case <-loggedIn:
+			return nil
 		case <-ctx.Done():
 			return errors.WithStack(ctx.Err())
 		}
 	}
 }
+
+// drain writes messages until nobody is waiting to queue one. It doesn't wait for the rate limiter unless there's
+// something to write.
+func (p *publisher) drain(ctx context.Context, t Transport) error {
+	for {
+		var out *outgoing
+		select {
+		case out = <-p.logins:
+		case out = <-p.priority:
+		default:
+			select {
+			case out = <-p.logins:
+			case out = <-p.priority:
+			case out = <-p.queue:
+			default:
+				return nil
+			}
+		}
+		if err := p.wait(ctx); err != nil {
+			if retryable(out.msg) {
+				p.pending = out
+			} else {
+				out.result <- err
+			}
+			return err
+		}
+		if err := p.write(ctx, t, out); err != nil {
+			return err
+		}
+	}
+}
+
+// wait waits until the rate limiter allows sending another message
+func (p *publisher) wait(ctx context.Context) error {
+	if d := p.limiter.delay(); d > 0 {
+		p.logger.DebugContext(ctx, "rate limited, waiting", "delay", d)
+	}
+	return p.limiter.wait(ctx)
+}
+
+// write writes a message and sends its result, unless it failed to write and can be retried on the next connection.
+// Errors are only returned for failed writes, as the connection can't be used after one.
+func (p *publisher) write(ctx context.Context, t Transport, out *outgoing) error {
+	serialized, err := out.msg.Serialize()
+	if err != nil {
+		p.logger.WarnContext(ctx, "dropping invalid message", "message", out.msg, "error", errors.WithStack(err))
+		out.result <- errors.WithMessage(err, "invalid message")
+		return nil
+	}
+	p.logger.DebugContext(ctx, "sending message", "message", out.msg)
+	p.limiter.take()
+	writeCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	if err := t.WriteFrame(writeCtx, []byte(serialized)); err != nil {
+		err = errors.Wrap(err, "failed to write message")
+		out.attempts++
+		if out.attempts < maxWriteAttempts && retryable(out.msg) {
+			p.pending = out
+		} else {
+			out.result <- errors.WithMessagef(err, "giving up after %d attempts", out.attempts)
+		}
+		return err
+	}
+	p.counts.payloadWritten.Add(int64(len(serialized)))
+	out.result <- nil
+	return nil
+}
+
+func (p *publisher) setStopped(stopped chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = stopped
+}
+
+// sending returns a channel that's closed once run stops writing, or nil if it isn't writing everything queued
+// because we're disconnected or haven't logged in yet
+func (p *publisher) sending() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
+
+// flush waits until everything queued before the call has been written. Nothing can be written while disconnected or
+// logging in, so it returns straight away then, and as soon as the connection drops, leaving whatever is still queued
+// for the next connection.
+func (p *publisher) flush(ctx context.Context) error {
+	stopped := p.sending()
+	if stopped == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	select {
+	case p.flushes <- done:
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+	select {
+	case <-done:
+		return nil
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
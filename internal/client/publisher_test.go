@@ -0,0 +1,77 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gholden-go/internal/grammar"
+
+	"github.com/coder/websocket"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPriority(t *testing.T) {
+	require.True(t, isPriority(grammar.Choose{Room: "battle-gen9ou-1", Choices: []grammar.Choice{grammar.DefaultChoice{}}}))
+	require.True(t, isPriority(grammar.Undo{Room: "battle-gen9ou-1"}))
+	// Renames go on the login queue instead
+	require.False(t, isPriority(grammar.Rename{Username: "test"}))
+	require.False(t, isPriority(grammar.RawCommand{Command: "hello"}))
+	require.False(t, isPriority(grammar.Leave{Room: "lobby"}))
+}
+
+func TestPublisher_Retry(t *testing.T) {
+	received := make(chan string)
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		require.NoError(t, err)
+		for {
+			_, msg, err := c.Read(t.Context())
+			if err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}))
+	t.Cleanup(ws.Close)
+
+	queue := make(chan *outgoing, 3)
+	p := newPublisher(publisherOpts{
+		logins:   make(chan *outgoing),
+		queue:    queue,
+		priority: make(chan *outgoing),
+		limiter:  newRateLimiter(0, 0, 0, func() bool { return false }),
+		counts:   &byteCounts{},
+		timeout:  time.Second,
+		logger:   slogt.New(t),
+	})
+
+	// Already logged in, so everything is written right away
+	loggedIn := make(chan struct{})
+	close(loggedIn)
+
+	// Writing to a broken connection fails the run, keeping the message for the next connection unless it's a rename
+	broken, _, err := websocket.Dial(t.Context(), ws.URL, nil)
+	require.NoError(t, err)
+	require.NoError(t, broken.CloseNow())
+	invalid := newOutgoing(grammar.Join{})
+	rename := newOutgoing(grammar.Rename{Username: "test", Assertion: "assertion"})
+	chat := newOutgoing(grammar.RawCommand{Command: "hello"})
+	queue <- invalid
+	queue <- rename
+	require.Error(t, p.run(t.Context(), &websocketTransport{conn: broken}, loggedIn))
+	require.ErrorContains(t, <-invalid.result, "invalid message")
+	require.ErrorContains(t, <-rename.result, "giving up after 1 attempts")
+	queue <- chat
+	require.Error(t, p.run(t.Context(), &websocketTransport{conn: broken}, loggedIn))
+	require.Empty(t, chat.result)
+
+	conn, _, err := websocket.Dial(t.Context(), ws.URL, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.CloseNow() })
+	go p.run(t.Context(), &websocketTransport{conn: conn}, loggedIn)
+	require.Equal(t, "hello", <-received)
+	require.NoError(t, <-chat.result)
+}
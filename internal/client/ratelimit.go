@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// rateLimiter is a token bucket keeping outgoing messages under Showdown's throttle, which allows a burst of messages
+// and then one message per interval. The interval depends on whether we're registered, as guests are throttled
+// harder. It's only used from the publisher's goroutine, except for its stats.
+type rateLimiter struct {
+	burst              int           // required
+	guestInterval      time.Duration // zero for no limit
+	registeredInterval time.Duration // zero for no limit
+	registered         func() bool   // required
+
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	stats  rateLimitStats
+}
+
+// rateLimitStats are updated atomically, so they can be read while the publisher is running
+type rateLimitStats struct {
+	sent    atomic.Int64
+	delayed atomic.Int64
+	waited  atomic.Int64 // nanoseconds
+}
+
+func newRateLimiter(burst int, guestInterval, registeredInterval time.Duration, registered func() bool) *rateLimiter {
+	l := &rateLimiter{
+		burst:              max(burst, 1),
+		guestInterval:      guestInterval,
+		registeredInterval: registeredInterval,
+		registered:         registered,
+		tokens:             float64(max(burst, 1)),
+		now:                time.Now,
+	}
+	l.last = l.now()
+	return l
+}
+
+func (l *rateLimiter) interval() time.Duration {
+	if l.registered() {
+		return l.registeredInterval
+	}
+	return l.guestInterval
+}
+
+// delay returns how long until a message can be sent, zero if one can be sent now
+func (l *rateLimiter) delay() time.Duration {
+	interval := l.interval()
+	if interval <= 0 {
+		return 0
+	}
+	now := l.now()
+	l.tokens = min(float64(l.burst), l.tokens+float64(max(now.Sub(l.last), 0))/float64(interval))
+	l.last = now
+	if l.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - l.tokens) * float64(interval))
+}
+
+// wait blocks until a message can be sent
+func (l *rateLimiter) wait(ctx context.Context) error {
+	start := l.now()
+	delayed := false
+	for {
+		d := l.delay()
+		if d == 0 {
+			break
+		}
+		delayed = true
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+	}
+	if delayed {
+		l.stats.delayed.Add(1)
+		l.stats.waited.Add(int64(l.now().Sub(start)))
+	}
+	return nil
+}
+
+// take uses up a token for a message being sent
+func (l *rateLimiter) take() {
+	l.stats.sent.Add(1)
+	if l.interval() > 0 {
+		l.tokens--
+	}
+}
+
+// logAttrs returns the stats as log attributes
+func (l *rateLimiter) logAttrs() []any {
+	return []any{
+		"sent", l.stats.sent.Load(),
+		"delayed", l.stats.delayed.Load(),
+		"waited", time.Duration(l.stats.waited.Load()),
+	}
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	registered := false
+	l := newRateLimiter(2, time.Second, 100*time.Millisecond, func() bool { return registered })
+	l.now = func() time.Time { return now }
+
+	// The burst can be sent right away
+	for range 2 {
+		require.Zero(t, l.delay())
+		l.take()
+	}
+	require.Equal(t, time.Second, l.delay())
+	now = now.Add(250 * time.Millisecond)
+	require.Equal(t, 750*time.Millisecond, l.delay())
+
+	// Registered users refill faster
+	registered = true
+	now = now.Add(100 * time.Millisecond)
+	require.Zero(t, l.delay())
+	l.take()
+
+	// Refills never go past the burst
+	now = now.Add(time.Hour)
+	for range 2 {
+		require.Zero(t, l.delay())
+		l.take()
+	}
+	require.NotZero(t, l.delay())
+	require.EqualValues(t, 5, l.stats.sent.Load())
+
+	// No interval means no limit
+	unlimited := newRateLimiter(0, 0, 0, func() bool { return false })
+	for range 10 {
+		require.NoError(t, unlimited.wait(t.Context()))
+		unlimited.take()
+	}
+	require.Zero(t, unlimited.stats.delayed.Load())
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"gholden-go/internal/grammar"
+)
+
+// redactHandler hides secrets in log records before passing them on, so that assertions, passwords, challstrs and sid
+// cookies never end up in logs, even at debug level. Secrets are recognized by the attribute's key, by values that are
+// grammar.Redactable, and in strings and errors by the shape of raw protocol messages, login responses and cookies.
+type redactHandler struct {
+	handler slog.Handler
+}
+
+// newRedactHandler wraps handler, unless it already redacts
+func newRedactHandler(handler slog.Handler) slog.Handler {
+	if _, ok := handler.(*redactHandler); ok {
+		return handler
+	}
+	return &redactHandler{handler: handler}
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.handler.Handle(ctx, redacted)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &redactHandler{handler: h.handler.WithAttrs(redacted)}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{handler: h.handler.WithGroup(name)}
+}
+
+// secretKeys are attribute keys whose values are always hidden
+var secretKeys = map[string]bool{
+	"assertion":     true,
+	"authorization": true,
+	"challstr":      true,
+	"cookie":        true,
+	"pass":          true,
+	"password":      true,
+	"sid":           true,
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, grammar.Redacted)
+	}
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(redactString(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redacted[i] = redactAttr(ga)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case grammar.Redactable:
+			a.Value = slog.AnyValue(v.Redact())
+		case error:
+			if redactString(v.Error()) != v.Error() {
+				a.Value = slog.AnyValue(redactedError{err: v})
+			}
+		}
+	}
+	return a
+}
+
+var (
+	// |challstr|CHALLSTR in raw server messages
+	challstrPattern = regexp.MustCompile(`(\|challstr\|)[^\n]*`)
+	// /trn USERNAME,0,ASSERTION in raw client messages
+	renamePattern = regexp.MustCompile(`(/trn [^,\n]*,[^,\n]*,)[^\n]*`)
+	// "assertion":"..." in login responses
+	jsonSecretPattern = regexp.MustCompile(`("(?:assertion|challstr|pass|password|sid)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	// pass=... in form-encoded login requests and sid=... in cookies
+	formSecretPattern = regexp.MustCompile(`(\b(?:assertion|challstr|pass|password|sid)=)[^&;\s]*`)
+)
+
+// redactString hides the secrets in raw protocol messages, login requests and responses, and cookies
+func redactString(s string) string {
+	s = challstrPattern.ReplaceAllString(s, "${1}"+grammar.Redacted)
+	s = renamePattern.ReplaceAllString(s, "${1}"+grammar.Redacted)
+	s = jsonSecretPattern.ReplaceAllString(s, `${1}"`+grammar.Redacted+`"`)
+	return formSecretPattern.ReplaceAllString(s, "${1}"+grammar.Redacted)
+}
+
+// redactedError hides the secrets in the message of err, including when it's formatted with its stack trace
+type redactedError struct {
+	err error
+}
+
+func (e redactedError) Error() string {
+	return redactString(e.err.Error())
+}
+
+func (e redactedError) Unwrap() error {
+	return e.err
+}
+
+func (e redactedError) Format(s fmt.State, verb rune) {
+	_, _ = fmt.Fprint(s, redactString(fmt.Sprintf(fmt.FormatString(s, verb), e.err)))
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gholden-go/internal/grammar"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_RedactsSecrets(t *testing.T) {
+	ls := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.URL.Path != "/api/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "secret-sid"})
+		_, err := w.Write([]byte(`]{"actionsuccess":true,"assertion":"assertion-Bot","curuser":{"loggedin":true,"username":"Bot"}}`))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ls.Close)
+	loggedIn := make(chan string)
+	ws := httptest.NewServer(websocketPool(t, loggedIn))
+	t.Cleanup(ws.Close)
+
+	var logs strings.Builder
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	session, err := NewSession(Config{
+		Address:         ws.URL,
+		LoginEndpoints:  []string{ls.URL + "/api/login"},
+		ActionEndpoints: []string{ls.URL + "/action.php"},
+		Timeout:         time.Second,
+		ShutdownTimeout: time.Second,
+	}, Credentials{Username: "Bot", Password: "hunter2"}, logger)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(t.Context())
+	runErr := make(chan error)
+	go func() {
+		runErr <- session.Run(ctx)
+	}()
+	select {
+	case <-loggedIn:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for login")
+	}
+	cancel()
+	require.NoError(t, <-runErr)
+
+	// Everything was logged at debug level, but none of the secrets
+	require.Contains(t, logs.String(), "message received")
+	require.Contains(t, logs.String(), "sending message")
+	for _, secret := range []string{"hunter2", "4|abc", "assertion-Bot", "secret-sid"} {
+		require.NotContains(t, logs.String(), secret)
+	}
+}
+
+func TestRedactHandler(t *testing.T) {
+	var logs strings.Builder
+	logger := slog.New(newRedactHandler(slog.NewJSONHandler(&logs, nil))).With("password", "hunter2")
+	logger.WithGroup("request").Info(
+		"login",
+		"challstr", "4|abc",
+		"frame", "|challstr|4|abc",
+		"form", "name=Bot&pass=hunter2&challstr=4%7Cabc",
+		"body", `]{"actionsuccess":true,"assertion":"assertion-Bot"}`,
+		"cookie", "sid=secret-sid",
+		"header", "sid=secret-sid; Path=/",
+		"message", grammar.Rename{Username: "Bot", Assertion: "assertion-Bot"},
+		"input", "/trn Bot,0,assertion-Bot",
+		"error", errors.Errorf("unexpected token %q", "|challstr|4|abc"),
+		slog.Group("nested", "pass", "hunter2", "text", "/trn Bot,0,assertion-Bot"),
+		"username", "Bot",
+	)
+	for _, secret := range []string{"hunter2", "4|abc", "4%7Cabc", "assertion-Bot", "secret-sid"} {
+		require.NotContains(t, logs.String(), secret)
+	}
+	require.Contains(t, logs.String(), `"username":"Bot"`)
+	require.Contains(t, logs.String(), `/trn Bot,0,[REDACTED]`)
+
+	// Errors are still formatted with their stack trace, and unwrap to the original error
+	err := redactedError{err: errors.New("|challstr|4|abc")}
+	require.Equal(t, "|challstr|[REDACTED]", err.Error())
+	require.Contains(t, fmt.Sprintf("%+v", err), "client.TestRedactHandler")
+	require.NotContains(t, fmt.Sprintf("%+v", err), "4|abc")
+	require.ErrorIs(t, err, err.err)
+}
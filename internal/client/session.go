@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gholden-go/internal/grammar"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Session is one account's connection to the server. It logs in with its credentials on every connection, and keeps
+// track of the account's state across reconnects.
+type Session struct {
+	config          Config
+	username        string
+	logger          *slog.Logger
+	httpClient      *http.Client
+	websocketClient *http.Client
+	counts          *byteCounts
+	subscriber      *subscriber
+	publisher       *publisher
+	controller      *controller
+	events          *bus
+}
+
+// NewSession returns a session that connects once Run is called. Zero config fields that can't be used as is are set
+// to their defaults, as documented on Config. Every log line is tagged with the session's username, and has secrets
+// such as assertions and passwords redacted.
+func NewSession(config Config, credentials Credentials, logger *slog.Logger) (*Session, error) {
+	config = config.withDefaults()
+	s := &Session{
+		config:   config,
+		username: credentials.Username,
+		counts:   &byteCounts{},
+	}
+	if s.username == "" {
+		// Random names are already IDs, so they're the same in getassertion requests and /trn commands
+		s.username = "test" + strings.ReplaceAll(uuid.New().String(), "-", "")[:12]
+	}
+	// Sessions handle the account's secrets, so they're hidden from logs whichever logger we're given
+	s.logger = slog.New(newRedactHandler(logger.Handler())).With("session", s.username)
+	s.events = newBus(s.logger)
+
+	var sidPath string
+	if config.SessionDir != "" {
+		sidPath = filepath.Join(config.SessionDir, grammar.ToID(s.username)+".sid")
+	}
+	sid, err := loadSIDStore(sidPath)
+	if err != nil {
+		return nil, err
+	}
+	if s.httpClient, err = config.newHTTPClient(); err != nil {
+		return nil, err
+	}
+	if s.websocketClient, err = config.newWebsocketClient(s.counts); err != nil {
+		return nil, err
+	}
+
+	// Incoming and outgoing messages are queued independently of the connection, so they survive reconnects
+	incomingMessages := make(chan incomingMessage)
+	loginMessages := make(chan *outgoing)
+	outgoingMessages := make(chan *outgoing)
+	priorityMessages := make(chan *outgoing)
+	s.subscriber = newSubscriber(incomingMessages, s.logger, config.Timeout, config.ReadIdleTimeout, s.counts)
+	s.controller = newController(controllerOpts{
+		loginMessagesCh:    loginMessages,
+		outgoingMessagesCh: outgoingMessages,
+		priorityMessagesCh: priorityMessages,
+		incomingMessagesCh: incomingMessages,
+		httpClient:         s.httpClient,
+		loginEndpoints:     config.LoginEndpoints,
+		actionEndpoints:    config.ActionEndpoints,
+		loginBackoff: func() *backoff {
+			return newBackoff(config.LoginRetryInitialDelay, config.LoginRetryMaxDelay, config.LoginRetryMaxAttempts, 0)
+		},
+		timeout:  config.Timeout,
+		username: s.username,
+		password: credentials.Password,
+		sid:      sid,
+		events:   s.events,
+		logger:   s.logger,
+	})
+	s.publisher = newPublisher(publisherOpts{
+		logins:   loginMessages,
+		queue:    outgoingMessages,
+		priority: priorityMessages,
+		limiter: newRateLimiter(config.RateLimitBurst, config.RateLimitGuest, config.RateLimitRegistered, func() bool {
+			_, named := s.controller.state.user()
+			return named
+		}),
+		counts:  s.counts,
+		timeout: config.Timeout,
+		logger:  s.logger,
+	})
+	return s, nil
+}
+
+// Run connects to the server, and reconnects whenever the connection drops, until ctx is done. It then shuts down
+// gracefully as configured by the ShutdownPolicy and ShutdownTimeout, and stops every subscription once their handlers
+// return. Run must only be called once.
+func (s *Session) Run(ctx context.Context) error {
+	defer s.events.close()
+	// The connection outlives ctx so that we can shut down gracefully once ctx is done
+	g, gctx := errgroup.WithContext(context.WithoutCancel(ctx))
+	closing := make(chan struct{})
+	g.Go(func() error {
+		if err := s.connect(gctx, closing); err != nil {
+			return errors.WithMessage(err, "error running connection")
+		}
+		// Closed after shutting down, so stop everything else
+		return errShutdown
+	})
+	g.Go(func() error {
+		err := s.controller.handleIncoming(gctx)
+		return errors.WithMessage(err, "error running controller")
+	})
+	var shutdownErr error
+	g.Go(func() error {
+		select {
+		case <-gctx.Done():
+			return errors.WithStack(gctx.Err())
+		case <-ctx.Done():
+		}
+		shutdownErr = s.shutdown(gctx)
+		close(closing)
+		return nil
+	})
+
+	if err := g.Wait(); !errors.Is(err, errShutdown) {
+		return err
+	}
+	return shutdownErr
+}
+
+// Username returns the name the session logs in as
+func (s *Session) Username() string {
+	return s.username
+}
+
+// LoggedIn is closed once the server has confirmed the session's first login
+func (s *Session) LoggedIn() <-chan struct{} {
+	return s.controller.loggedIn
+}
+
+// Rooms returns the rooms the session is currently in, sorted
+func (s *Session) Rooms() []string {
+	return s.controller.state.rooms()
+}
+
+// Stats are counters covering every connection of a session
+type Stats struct {
+	// MessagesSent counts the messages written to the server
+	MessagesSent int64
+	// MessagesDelayed counts the messages held back by rate limiting, which waited RateLimitWait in total
+	MessagesDelayed int64
+	RateLimitWait   time.Duration
+	// PayloadRead and PayloadWritten count the bytes of messages as read and written
+	PayloadRead    int64
+	PayloadWritten int64
+	// WireRead and WireWritten count the bytes that went over the network after compression and framing, for
+	// websocket connections only
+	WireRead    int64
+	WireWritten int64
+}
+
+// Stats returns the session's counters so far. It's safe to call while the session is running.
+func (s *Session) Stats() Stats {
+	limiter := &s.publisher.limiter.stats
+	return Stats{
+		MessagesSent:    limiter.sent.Load(),
+		MessagesDelayed: limiter.delayed.Load(),
+		RateLimitWait:   time.Duration(limiter.waited.Load()),
+		PayloadRead:     s.counts.payloadRead.Load(),
+		PayloadWritten:  s.counts.payloadWritten.Load(),
+		WireRead:        s.counts.wireRead.Load(),
+		WireWritten:     s.counts.wireWritten.Load(),
+	}
+}
+
+// Send queues msg and waits until it has been written to the connection. Messages sent while disconnected are written
+// once the session reconnects.
+func (s *Session) Send(ctx context.Context, msg grammar.ClientMessage) error {
+	return s.controller.send(ctx, msg)
+}
+
+// Subscribe calls handler with every event received from the server that matches opts.Filter, until the subscription
+// is unsubscribed or the session stops. Each subscription handles its events one at a time in the order they were
+// received, independently of other subscriptions. Events are published after the session has updated its own state,
+// so e.g. Rooms already includes the room of an init event.
+func (s *Session) Subscribe(handler Handler, opts SubscribeOptions) *Subscription {
+	return s.events.subscribe(handler, opts)
+}
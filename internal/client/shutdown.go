@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"strings"
+
+	"gholden-go/internal/grammar"
+
+	"github.com/pkg/errors"
+)
+
+// errShutdown cancels everything still running once shutdown is done, and tells the connection to close with a
+// normal closure instead of dropping it
+var errShutdown = errors.New("shutting down")
+
+type shutdownPolicy string
+
+const (
+	// shutdownNone leaves rooms and battles as they are
+	shutdownNone shutdownPolicy = "none"
+	// shutdownLeave leaves every joined room. Battles carry on without us until the timer runs out.
+	shutdownLeave shutdownPolicy = "leave"
+	// shutdownForfeit forfeits every battle, then leaves every joined room
+	shutdownForfeit shutdownPolicy = "forfeit"
+)
+
+// shutdown runs once the session's context is done. It sends whatever the policy calls for to the rooms we're in, then waits for
+// the publisher to write everything queued, giving up after the ShutdownTimeout. It returns straight away while
+// disconnected, as there's nothing to leave and nothing can be written.
+func (s *Session) shutdown(ctx context.Context) error {
+	c := s.config
+	ctx, cancel := context.WithTimeout(ctx, c.ShutdownTimeout)
+	defer cancel()
+	s.logger.InfoContext(ctx, "shutting down", "policy", c.ShutdownPolicy)
+
+	// Anything but leave and forfeit, including an unset policy, leaves rooms and battles as they are. So does being
+	// disconnected, as the server already dropped us from every room.
+	var msgs []grammar.ClientMessage
+	rooms := s.controller.state.rooms()
+	if s.publisher.sending() == nil {
+		s.logger.InfoContext(ctx, "not connected, leaving rooms as they are", "rooms", len(rooms))
+		rooms = nil
+	}
+	switch shutdownPolicy(c.ShutdownPolicy) {
+	case shutdownForfeit:
+		for _, room := range rooms {
+			if strings.HasPrefix(room, "battle-") {
+				msgs = append(msgs, grammar.Forfeit{Room: room})
+			}
+		}
+		fallthrough
+	case shutdownLeave:
+		for _, room := range rooms {
+			msgs = append(msgs, grammar.Leave{Room: room})
+		}
+	}
+	for _, msg := range msgs {
+		if err := s.controller.send(ctx, msg); err != nil {
+			return errors.WithMessage(err, "failed to queue shutdown messages")
+		}
+	}
+	err := s.publisher.flush(ctx)
+	s.logger.InfoContext(ctx, "outgoing message stats", s.publisher.limiter.logAttrs()...)
+	return errors.WithMessage(err, "failed to drain outgoing messages")
+}
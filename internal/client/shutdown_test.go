@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLI_Shutdown(t *testing.T) {
+	doneCh := make(chan struct{})
+	helper := &loginHelper{
+		doneCh:  doneCh,
+		loginCh: make(chan loginInfo),
+	}
+	ls := httptest.NewServer(helper.loginServer(t))
+	t.Cleanup(ls.Close)
+	received := make(chan []string, 1)
+	ws := httptest.NewServer(helper.websocketShutdown(t, received))
+	t.Cleanup(ws.Close)
+
+	stdin, stdinWriter := io.Pipe()
+	t.Cleanup(func() {
+		if err := stdinWriter.Close(); err != nil {
+			t.Log("error closing stdinWriter", err)
+		}
+	})
+	ctx, cancel := context.WithCancel(t.Context())
+	c := &CLI{
+		Config: Config{
+			Address:         ws.URL,
+			LoginEndpoints:  []string{ls.URL},
+			ActionEndpoints: []string{ls.URL},
+			Timeout:         time.Second,
+			ShutdownTimeout: time.Second,
+			ShutdownPolicy:  string(shutdownForfeit),
+		},
+		Logger: slogt.New(t, slogt.JSON()),
+		Stdin:  stdin,
+		Stdout: io.Discard,
+	}
+	runErr := make(chan error)
+	go func() {
+		runErr <- c.Run(ctx)
+	}()
+	// Once this has been written, we're logged in and sending, so there's something to leave on shutdown
+	_, err := io.WriteString(stdinWriter, "lobby|hello\n")
+	require.NoError(t, err)
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for login")
+	}
+
+	cancel()
+	select {
+	case err := <-runErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for shutdown")
+	}
+	require.Equal(t, []string{
+		"battle-gen9ou-1|/forfeit",
+		"|/leave battle-gen9ou-1",
+		"|/leave lobby",
+	}, <-received)
+}
+
+func TestSession_ShutdownWhileDisconnected(t *testing.T) {
+	ls := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprintf(w, "assertion-%s", r.URL.Query().Get("userid"))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(ls.Close)
+	// The first connection joins lobby and logs in, then drops once the test is ready. Reconnecting fails from then on.
+	drop := make(chan struct{})
+	redialed := make(chan struct{}, 1)
+	var count atomic.Int32
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count.Add(1) > 1 {
+			select {
+			case redialed <- struct{}{}:
+			default:
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		c, err := websocket.Accept(w, r, nil)
+		require.NoError(t, err)
+		for _, msg := range []string{">lobby\n|init|chat", "|challstr|4|abc"} {
+			require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte(msg)))
+		}
+		_, _, err = c.Read(t.Context())
+		require.NoError(t, err)
+		require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte("|updateuser| Bot|1|1|{}")))
+		<-drop
+		require.NoError(t, c.CloseNow())
+	}))
+	t.Cleanup(ws.Close)
+
+	session, err := NewSession(Config{
+		Address:               ws.URL,
+		LoginEndpoints:        []string{ls.URL},
+		ActionEndpoints:       []string{ls.URL},
+		Timeout:               time.Second,
+		ReconnectInitialDelay: 10 * time.Millisecond,
+		ReconnectMaxDelay:     10 * time.Millisecond,
+		ShutdownTimeout:       time.Minute,
+		ShutdownPolicy:        string(shutdownLeave),
+	}, Credentials{Username: "Bot"}, slogt.New(t, slogt.JSON()))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(t.Context())
+	runErr := make(chan error)
+	go func() {
+		runErr <- session.Run(ctx)
+	}()
+	select {
+	case <-session.LoggedIn():
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for login")
+	}
+	require.Equal(t, []string{"lobby"}, session.Rooms())
+	close(drop)
+	select {
+	case <-redialed:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for reconnect")
+	}
+
+	// There's nothing to leave or flush, so shutting down doesn't wait for the ShutdownTimeout
+	cancel()
+	select {
+	case err := <-runErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for shutdown")
+	}
+}
+
+// websocketShutdown joins the client to a chat room and a battle before logging in, waits for the client to send
+// "lobby|hello", then sends everything received after that to received once the client closes the connection normally
+func (h *loginHelper) websocketShutdown(t *testing.T, received chan<- []string) http.HandlerFunc {
+	t.Helper()
+	before := []string{">lobby\n|init|chat", ">battle-gen9ou-1\n|init|battle"}
+	return h.websocketServer(t, nil, before, func(c *websocket.Conn, info loginInfo) {
+		confirmLogin(t, c, info)
+		_, msg, err := c.Read(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, "lobby|hello", string(msg))
+		close(h.doneCh)
+
+		var msgs []string
+		for {
+			_, msg, err := c.Read(t.Context())
+			if err != nil {
+				require.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err), err)
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		received <- msgs
+	})
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// sidStore keeps the login server's sid cookie, which stays logged in to the account it was issued for. Saving it
+// lets later runs log in with upkeep instead of the password. Like a cookie jar, it remembers the host that issued
+// the sid, which is the only one it's sent to.
+type sidStore struct {
+	mu sync.Mutex
+	savedSID
+	// path is where the sid is saved, empty to only keep it in memory
+	path string
+}
+
+// savedSID is the sid along with the host, including the port, that issued it
+type savedSID struct {
+	Host string `json:"host"`
+	SID  string `json:"sid"`
+}
+
+// loadSIDStore returns a store saving to path, starting with the sid saved there if any
+func loadSIDStore(path string) (*sidStore, error) {
+	s := &sidStore{path: path}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read login session")
+	}
+	// Sessions saved without their host can't be sent anywhere, so they're dropped like expired ones
+	if err := json.Unmarshal(b, &s.savedSID); err != nil || s.Host == "" {
+		s.savedSID = savedSID{}
+	}
+	return s, nil
+}
+
+// get returns the sid and the host that issued it
+func (s *sidStore) get() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Host, s.SID
+}
+
+// sidFor returns the sid to send to endpoint, empty unless endpoint is on the host that issued it
+func (s *sidStore) sidFor(endpoint string) string {
+	host, sid := s.get()
+	if sid == "" || endpointHost(endpoint) != host {
+		return ""
+	}
+	return sid
+}
+
+// set replaces the sid with one issued by host, saving it if it changed. An empty sid removes the saved one.
+func (s *sidStore) set(host, sid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sid == "" {
+		host = ""
+	}
+	next := savedSID{Host: host, SID: sid}
+	if next == s.savedSID {
+		return nil
+	}
+	s.savedSID = next
+	if s.path == "" {
+		return nil
+	}
+	if sid == "" {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrap(err, "failed to remove login session")
+		}
+		return nil
+	}
+	b, err := json.Marshal(next)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithMessage(writeFileAtomic(s.path, append(b, '\n')), "failed to save login session")
+}
+
+// endpointHost returns the host and port of endpoint, which is what sids are scoped to
+func endpointHost(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// writeFileAtomic replaces the file at path with one only readable by us, so that readers never see a partial write
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errors.WithStack(err)
+	}
+	// Temp files are created with 0600
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(f.Name(), path))
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestController_sidHost(t *testing.T) {
+	cookies := make(chan string, 1)
+	handler := func(setSID bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var sid string
+			if cookie, err := r.Cookie("sid"); err == nil {
+				sid = cookie.Value
+			}
+			cookies <- sid
+			if setSID {
+				http.SetCookie(w, &http.Cookie{Name: "sid", Value: "issued"})
+			}
+		}
+	}
+	issuer := httptest.NewServer(handler(true))
+	t.Cleanup(issuer.Close)
+	other := httptest.NewServer(handler(false))
+	t.Cleanup(other.Close)
+
+	sid, err := loadSIDStore("")
+	require.NoError(t, err)
+	c := &controller{
+		httpClient:      http.DefaultClient,
+		timeout:         time.Second,
+		sid:             sid,
+		actionEndpoints: []string{other.URL},
+		loginBackoff: func() *backoff {
+			return newBackoff(time.Millisecond, time.Millisecond, 1, 0)
+		},
+		logger: slogt.New(t),
+	}
+	newRequest := func(ctx context.Context, endpoint string) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	}
+
+	_, err = c.doLoginRequest(t.Context(), []string{issuer.URL}, newRequest)
+	require.NoError(t, err)
+	require.Empty(t, <-cookies)
+
+	// The sid is only sent back to the host that issued it
+	_, err = c.doLoginRequest(t.Context(), []string{other.URL}, newRequest)
+	require.NoError(t, err)
+	require.Empty(t, <-cookies)
+	_, err = c.doLoginRequest(t.Context(), []string{issuer.URL}, newRequest)
+	require.NoError(t, err)
+	require.Equal(t, "issued", <-cookies)
+
+	// Upkeep can't use the sid without an action endpoint on its host, but keeps it
+	_, err = c.upkeep(t.Context(), loginInput{Name: "Bot", Challstr: "4|abc"})
+	require.ErrorIs(t, err, errSessionExpired)
+	require.Empty(t, cookies)
+	host, value := c.sid.get()
+	require.Equal(t, strings.TrimPrefix(issuer.URL, "http://"), host)
+	require.Equal(t, "issued", value)
+}
+
+func TestSession_Upkeep(t *testing.T) {
+	var (
+		mu             sync.Mutex
+		validSID       string
+		passwordLogins int
+	)
+	ls := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		require.NoError(t, r.ParseForm())
+		switch r.URL.Path {
+		case "/api/login":
+			require.Equal(t, "hunter2", r.PostForm.Get("pass"))
+			passwordLogins++
+			validSID = fmt.Sprintf("sid%d", passwordLogins)
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: validSID})
+			_, err := w.Write([]byte(`]{"actionsuccess":true,"assertion":"assertion-Bot","curuser":{"loggedin":true,"username":"Bot"}}`))
+			require.NoError(t, err)
+		case "/action.php":
+			require.Equal(t, "upkeep", r.PostForm.Get("act"))
+			cookie, err := r.Cookie("sid")
+			require.NoError(t, err)
+			if cookie.Value != validSID {
+				_, err = w.Write([]byte(`]{"loggedin":false,"username":"Guest 1"}`))
+				require.NoError(t, err)
+				return
+			}
+			_, err = w.Write([]byte(`]{"loggedin":true,"username":"Bot","assertion":"assertion-Bot"}`))
+			require.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ls.Close)
+	loggedIn := make(chan string)
+	ws := httptest.NewServer(websocketPool(t, loggedIn))
+	t.Cleanup(ws.Close)
+
+	dir := t.TempDir()
+	run := func() {
+		t.Helper()
+		session, err := NewSession(Config{
+			Address:         ws.URL,
+			LoginEndpoints:  []string{ls.URL + "/api/login"},
+			ActionEndpoints: []string{ls.URL + "/action.php"},
+			SessionDir:      dir,
+			Timeout:         time.Second,
+			ShutdownTimeout: time.Second,
+		}, Credentials{Username: "Bot", Password: "hunter2"}, slogt.New(t, slogt.JSON()))
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(t.Context())
+		runErr := make(chan error)
+		go func() {
+			runErr <- session.Run(ctx)
+		}()
+		select {
+		case username := <-loggedIn:
+			require.Equal(t, "Bot", username)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for login")
+		}
+		cancel()
+		require.NoError(t, <-runErr)
+	}
+
+	// The first login needs the password, and saves the sid for the next run
+	run()
+	require.Equal(t, 1, passwordLogins)
+	info, err := os.Stat(filepath.Join(dir, "bot.sid"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	run()
+	require.Equal(t, 1, passwordLogins)
+
+	// Once the session expires, we fall back to the password
+	mu.Lock()
+	validSID = ""
+	mu.Unlock()
+	run()
+	require.Equal(t, 2, passwordLogins)
+	sid, err := os.ReadFile(filepath.Join(dir, "bot.sid"))
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`{"host":%q,"sid":"sid2"}`, strings.TrimPrefix(ls.URL, "http://")), string(sid))
+}
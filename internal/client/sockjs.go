@@ -0,0 +1,331 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coder/websocket"
+	"github.com/pkg/errors"
+)
+
+// sockjsConn reads and writes raw SockJS frames, e.g. `o`, `h`, `a["..."]` and `c[3000,"..."]`
+type sockjsConn interface {
+	readFrame(ctx context.Context) ([]byte, error)
+	// send sends a JSON array of messages
+	send(ctx context.Context, payload []byte) error
+	close() error
+	closeNow() error
+}
+
+// sockjsTransport unwraps the messages in SockJS frames. Showdown's SockJS endpoints live under the same path as its
+// raw websocket, e.g. https://sim3.psim.us/showdown/<server>/<session>/websocket for https://sim3.psim.us/showdown.
+type sockjsTransport struct {
+	conn     sockjsConn
+	activity chan<- struct{}
+	// pending holds the messages of the last frame that haven't been read yet
+	pending [][]byte
+}
+
+func (t *sockjsTransport) ReadFrame(ctx context.Context) ([]byte, error) {
+	for len(t.pending) == 0 {
+		frame, err := t.conn.readFrame(ctx)
+		if err != nil {
+			return nil, err
+		}
+		notify(t.activity)
+		if t.pending, err = decodeSockJSFrame(frame); err != nil {
+			return nil, err
+		}
+	}
+	msg := t.pending[0]
+	t.pending = t.pending[1:]
+	return msg, nil
+}
+
+func (t *sockjsTransport) WriteFrame(ctx context.Context, frame []byte) error {
+	payload, err := json.Marshal([]string{string(frame)})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode sockjs message")
+	}
+	return t.conn.send(ctx, payload)
+}
+
+func (t *sockjsTransport) Close() error {
+	return t.conn.close()
+}
+
+func (t *sockjsTransport) CloseNow() error {
+	return t.conn.closeNow()
+}
+
+// decodeSockJSFrame returns the messages in a frame, which are only sent in `a` and `m` frames
+func decodeSockJSFrame(frame []byte) ([][]byte, error) {
+	if len(frame) == 0 {
+		return nil, nil
+	}
+	switch frame[0] {
+	case 'o', 'h':
+		// Opened or heartbeat. xhr-streaming also starts with a line of `h`s, to get proxies to start streaming.
+		return nil, nil
+	case 'a':
+		var msgs []string
+		if err := json.Unmarshal(frame[1:], &msgs); err != nil {
+			return nil, errors.Wrapf(err, "invalid sockjs array frame %q", frame)
+		}
+		decoded := make([][]byte, 0, len(msgs))
+		for _, msg := range msgs {
+			decoded = append(decoded, []byte(msg))
+		}
+		return decoded, nil
+	case 'm':
+		var msg string
+		if err := json.Unmarshal(frame[1:], &msg); err != nil {
+			return nil, errors.Wrapf(err, "invalid sockjs message frame %q", frame)
+		}
+		return [][]byte{[]byte(msg)}, nil
+	case 'c':
+		var closed []any
+		if err := json.Unmarshal(frame[1:], &closed); err != nil || len(closed) != 2 {
+			return nil, errors.Errorf("invalid sockjs close frame %q", frame)
+		}
+		return nil, errors.Errorf("server closed the sockjs session: %v %v", closed[0], closed[1])
+	default:
+		return nil, errors.WithMessagef(errUnsupportedMessage, "unknown sockjs frame %q", frame)
+	}
+}
+
+// awaitOpen waits for the `o` frame that starts every session
+func awaitOpen(ctx context.Context, conn sockjsConn) error {
+	for {
+		frame, err := conn.readFrame(ctx)
+		if err != nil {
+			return errors.WithMessage(err, "failed waiting for sockjs session to open")
+		}
+		switch {
+		case bytes.Equal(frame, []byte("o")):
+			return nil
+		case bytes.HasPrefix(frame, []byte("h")):
+		default:
+			if _, err := decodeSockJSFrame(frame); err != nil {
+				return err
+			}
+			return errors.Errorf("expected sockjs open frame, got %q", frame)
+		}
+	}
+}
+
+// sockjsURL returns the URL of a new session's endpoint under address, with the scheme set for the transport
+func sockjsURL(address, endpoint string, websocketScheme bool) (string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid address %q", address)
+	}
+	switch {
+	case websocketScheme && u.Scheme == "http":
+		u.Scheme = "ws"
+	case websocketScheme && u.Scheme == "https":
+		u.Scheme = "wss"
+	case !websocketScheme && u.Scheme == "ws":
+		u.Scheme = "http"
+	case !websocketScheme && u.Scheme == "wss":
+		u.Scheme = "https"
+	}
+	const sessionChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	session := make([]byte, 8)
+	for i := range session {
+		session[i] = sessionChars[rand.IntN(len(sessionChars))]
+	}
+	u.Path = fmt.Sprintf("%s/%03d/%s/%s", strings.TrimSuffix(u.Path, "/"), rand.IntN(1000), session, endpoint)
+	return u.String(), nil
+}
+
+// sockjsWebsocketTransport is SockJS framing over a websocket, which unlike xhr-streaming can be pinged
+type sockjsWebsocketTransport struct {
+	*sockjsTransport
+	ws *websocket.Conn
+}
+
+func dialSockJSWebsocket(
+	ctx context.Context,
+	address string,
+	opts *websocket.DialOptions,
+	readLimit int64,
+	activity chan<- struct{},
+) (*sockjsWebsocketTransport, error) {
+	u, err := sockjsURL(address, "websocket", true)
+	if err != nil {
+		return nil, err
+	}
+	ws, _, err := websocket.Dial(ctx, u, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dial %s", u)
+	}
+	if readLimit > 0 {
+		ws.SetReadLimit(readLimit)
+	}
+	conn := &sockjsWebsocket{ws: ws}
+	if err := awaitOpen(ctx, conn); err != nil {
+		_ = conn.closeNow()
+		return nil, err
+	}
+	return &sockjsWebsocketTransport{
+		sockjsTransport: &sockjsTransport{conn: conn, activity: activity},
+		ws:              ws,
+	}, nil
+}
+
+func (t *sockjsWebsocketTransport) Ping(ctx context.Context) error {
+	return errors.WithStack(t.ws.Ping(ctx))
+}
+
+// sockjsWebsocket sends each SockJS frame as a websocket text message
+type sockjsWebsocket struct {
+	ws *websocket.Conn
+}
+
+func (c *sockjsWebsocket) readFrame(ctx context.Context) ([]byte, error) {
+	_, frame, err := c.ws.Read(ctx)
+	return frame, errors.WithStack(err)
+}
+
+func (c *sockjsWebsocket) send(ctx context.Context, payload []byte) error {
+	return errors.WithStack(c.ws.Write(ctx, websocket.MessageText, payload))
+}
+
+func (c *sockjsWebsocket) close() error {
+	return errors.WithStack(c.ws.Close(websocket.StatusNormalClosure, "closing"))
+}
+
+func (c *sockjsWebsocket) closeNow() error {
+	if err := c.ws.CloseNow(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// sockjsXHR receives frames as lines of a long-lived streaming response, and sends each payload as its own request.
+// It's the fallback for when websockets are blocked, e.g. by a proxy.
+type sockjsXHR struct {
+	client  *http.Client
+	sendURL string
+	frames  chan []byte
+	// done is closed once the stream has ended, after setting err
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+}
+
+func dialSockJSXHR(
+	ctx context.Context,
+	address string,
+	client *http.Client,
+	activity chan<- struct{},
+) (*sockjsTransport, error) {
+	streamURL, err := sockjsURL(address, "xhr_streaming", false)
+	if err != nil {
+		return nil, err
+	}
+	// The stream outlives the dial, so only the initial response is bound to ctx
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodPost, streamURL, nil)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "failed to create xhr-streaming request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrapf(err, "failed to open xhr-streaming session at %s", streamURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, errors.Errorf("xhr-streaming request failed with status %s", resp.Status)
+	}
+
+	conn := &sockjsXHR{
+		client:  client,
+		sendURL: strings.TrimSuffix(streamURL, "xhr_streaming") + "xhr_send",
+		frames:  make(chan []byte),
+		done:    make(chan struct{}),
+		cancel:  cancel,
+	}
+	go conn.stream(streamCtx, resp.Body)
+	err = awaitOpen(ctx, conn)
+	if !stop() || err != nil {
+		cancel()
+		return nil, cmp.Or(err, errors.WithStack(ctx.Err()))
+	}
+	return &sockjsTransport{conn: conn, activity: activity}, nil
+}
+
+// stream reads frames from the response body until it ends or ctx is done
+func (c *sockjsXHR) stream(ctx context.Context, body io.ReadCloser) {
+	defer close(c.done)
+	defer body.Close()
+	r := bufio.NewReader(body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			c.err = errors.Wrap(err, "xhr-streaming session ended")
+			return
+		}
+		select {
+		case c.frames <- bytes.TrimSuffix(line, []byte("\n")):
+		case <-ctx.Done():
+			c.err = errors.WithStack(ctx.Err())
+			return
+		}
+	}
+}
+
+func (c *sockjsXHR) readFrame(ctx context.Context) ([]byte, error) {
+	select {
+	case frame := <-c.frames:
+		return frame, nil
+	case <-c.done:
+		return nil, c.err
+	case <-ctx.Done():
+		// Match websockets, where cancelling a read drops the connection
+		c.cancel()
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+func (c *sockjsXHR) send(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.sendURL, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "failed to create xhr-send request")
+	}
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send xhr-send request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return errors.Errorf("xhr-send request failed with status %s", resp.Status)
+	}
+	return nil
+}
+
+// close ends the session. SockJS has no close handshake for clients, the server notices the stream going away.
+func (c *sockjsXHR) close() error {
+	return c.closeNow()
+}
+
+func (c *sockjsXHR) closeNow() error {
+	c.cancel()
+	return nil
+}
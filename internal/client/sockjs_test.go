@@ -0,0 +1,160 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLI_SockJS(t *testing.T) {
+	tests := []struct {
+		name      string
+		websocket bool
+	}{
+		{name: "websocket", websocket: true},
+		{name: "xhr-streaming fallback", websocket: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doneCh := make(chan struct{})
+			helper := &loginHelper{
+				doneCh:  doneCh,
+				loginCh: make(chan loginInfo),
+			}
+			ls := httptest.NewServer(helper.loginServer(t))
+			t.Cleanup(ls.Close)
+			ss := httptest.NewServer(helper.sockjsLogin(t, tt.websocket))
+			t.Cleanup(ss.Close)
+
+			stdin, stdinWriter := io.Pipe()
+			t.Cleanup(func() {
+				if err := stdinWriter.Close(); err != nil {
+					t.Log("error closing stdinWriter", err)
+				}
+			})
+			c := &CLI{
+				Config: Config{
+					Address:         ss.URL + "/showdown",
+					Transport:       transportSockJS,
+					LoginEndpoints:  []string{ls.URL},
+					ActionEndpoints: []string{ls.URL},
+					Timeout:         time.Second,
+				},
+				Logger: slogt.New(t, slogt.JSON()),
+				Stdin:  stdin,
+				Stdout: io.Discard,
+			}
+			runInBackground(t, c.Run)
+			select {
+			case <-doneCh:
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "timed out waiting for login over sockjs")
+			}
+		})
+	}
+}
+
+func TestDecodeSockJSFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		want    []string
+		wantErr string
+	}{
+		{name: "open", frame: "o"},
+		{name: "heartbeat", frame: "h"},
+		{name: "xhr prelude", frame: strings.Repeat("h", 2048)},
+		{name: "array", frame: `a["|challstr|4|abc",">lobby\n|init|chat"]`, want: []string{"|challstr|4|abc", ">lobby\n|init|chat"}},
+		{name: "message", frame: `m"|updateuser| Guest 1|0|1|{}"`, want: []string{"|updateuser| Guest 1|0|1|{}"}},
+		{name: "close", frame: `c[3000,"Go away!"]`, wantErr: "server closed the sockjs session: 3000 Go away!"},
+		{name: "unknown", frame: "x", wantErr: `unknown sockjs frame "x": unsupported message`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := decodeSockJSFrame([]byte(tt.frame))
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			var got []string
+			for _, msg := range msgs {
+				got = append(got, string(msg))
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// sockjsLogin serves SockJS endpoints under /showdown, expecting a login like websocketLogin. Without websocket the
+// websocket endpoint isn't available, so the client has to fall back to xhr-streaming.
+func (h *loginHelper) sockjsLogin(t *testing.T, websocketEnabled bool) http.Handler {
+	t.Helper()
+	challstrFrame, err := json.Marshal([]string{challstrMsg})
+	require.NoError(t, err)
+	challstrFrame = append([]byte("a"), challstrFrame...)
+	expectTrn := func(info loginInfo, payload []byte) {
+		var msgs []string
+		require.NoError(t, json.Unmarshal(payload, &msgs))
+		require.Equal(t, []string{fmt.Sprintf(`|/trn %s,0,%s`, info.username, info.assertion)}, msgs)
+		close(h.doneCh)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/showdown/{server}/{session}/websocket", func(w http.ResponseWriter, r *http.Request) {
+		if !websocketEnabled {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		c, err := websocket.Accept(w, r, nil)
+		require.NoError(t, err)
+		require.NoError(t, c.Write(t.Context(), websocket.MessageText, []byte("o")))
+		require.NoError(t, c.Write(t.Context(), websocket.MessageText, challstrFrame))
+
+		var info loginInfo
+		select {
+		case info = <-h.loginCh:
+		case <-t.Context().Done():
+			require.FailNow(t, "context done before sockjs login")
+		}
+		_, payload, err := c.Read(t.Context())
+		require.NoError(t, err)
+		expectTrn(info, payload)
+		readUntilClosed(c)
+	})
+
+	// xhr_send needs the login info, but only the stream is around to receive it while the login is in progress
+	infoCh := make(chan loginInfo, 1)
+	mux.HandleFunc("POST /showdown/{server}/{session}/xhr_streaming", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		for _, frame := range [][]byte{[]byte(strings.Repeat("h", 2048)), []byte("o"), challstrFrame} {
+			_, err := w.Write(append(frame, '\n'))
+			require.NoError(t, err)
+		}
+		w.(http.Flusher).Flush()
+
+		select {
+		case info := <-h.loginCh:
+			infoCh <- info
+		case <-t.Context().Done():
+			require.FailNow(t, "context done before sockjs login")
+		}
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("POST /showdown/{server}/{session}/xhr_send", func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		expectTrn(<-infoCh, payload)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
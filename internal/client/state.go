@@ -1,27 +1,193 @@
 package client
 
 import (
-	"errors"
+	"cmp"
+	"context"
+	"slices"
 	"sync"
+
+	"github.com/pkg/errors"
 )
 
-type challstr struct {
-	mu       sync.Mutex
-	challstr string
-	set      chan struct{}
+// errStaleConnection is returned when updating state with a message read from a connection that has since been
+// replaced
+var errStaleConnection = errors.New("message is from a previous connection")
+
+// connState holds everything that's only valid for the lifetime of a single connection
+type connState struct {
+	// generation identifies the connection, starting at 1 for the first one
+	generation uint64
+	challstr   string
+	// challstrSeq counts the challstrs received on the connection, as the server may send a new one at any time
+	challstrSeq uint64
+	// user is the name the server last told us we're using, and named whether it's a name we chose rather than
+	// one assigned to a guest
+	user  string
+	named bool
+	// nameTaken is the server's message if it refused to let us use the name we logged in with
+	nameTaken string
+	rooms     map[string]struct{}
 }
 
+// state is shared by the controller and everything running on a connection. Connection scoped values are dropped
+// as a whole when a new connection starts, so nothing from a previous connection can be mistaken for the current one.
 type state struct {
-	challstr challstr
+	mu   sync.Mutex
+	conn connState
+	// changed is closed and replaced whenever conn changes, to wake up waiters
+	changed chan struct{}
+}
+
+func newState() *state {
+	return &state{
+		conn:    connState{rooms: map[string]struct{}{}},
+		changed: make(chan struct{}),
+	}
+}
+
+// challstrKey identifies a challstr received on a connection
+type challstrKey struct {
+	generation uint64
+	seq        uint64
+}
+
+// newConnection starts a new connection generation, forgetting everything about the previous connection, and
+// returns the new generation that messages read from the connection should be tagged with
+func (s *state) newConnection() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = connState{
+		generation: s.conn.generation + 1,
+		rooms:      map[string]struct{}{},
+	}
+	s.notify()
+	return s.conn.generation
 }
 
-func (s *state) setChallstr(challstr string) error {
-	s.challstr.mu.Lock()
-	defer s.challstr.mu.Unlock()
-	if s.challstr.challstr != "" {
-		return errors.New("challstr already set")
+// update applies f to the state of the given connection generation, or returns errStaleConnection if that connection
+// has since been replaced
+func (s *state) update(generation uint64, f func(c *connState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if generation != s.conn.generation {
+		return errors.WithMessagef(errStaleConnection, "got generation %d, current is %d", generation, s.conn.generation)
 	}
-	s.challstr.challstr = challstr
-	close(s.challstr.set)
+	f(&s.conn)
+	s.notify()
 	return nil
 }
+
+// notify wakes up all waiters, must be called with mu held
+func (s *state) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// setChallstr records a challstr received on the given connection, replacing any previous one
+func (s *state) setChallstr(generation uint64, challstr string) error {
+	return s.update(generation, func(c *connState) {
+		c.challstr = challstr
+		c.challstrSeq++
+	})
+}
+
+// waitChallstr waits for a challstr of the current connection newer than after, and returns it along with its key
+// to be passed as after on the next call. Pass the zero key to wait for the first challstr of the current
+// connection, or whichever one it's currently using. It returns ErrNameTaken instead once the server has refused our
+// name on the current connection, as logging in again with the same name won't help.
+func (s *state) waitChallstr(ctx context.Context, after challstrKey) (challstrKey, string, error) {
+	for {
+		s.mu.Lock()
+		key := challstrKey{generation: s.conn.generation, seq: s.conn.challstrSeq}
+		challstr := s.conn.challstr
+		nameTaken := s.conn.nameTaken
+		changed := s.changed
+		s.mu.Unlock()
+		if nameTaken != "" {
+			return challstrKey{}, "", errors.WithMessage(ErrNameTaken, nameTaken)
+		}
+		if key.seq > 0 && (key.generation != after.generation || key.seq > after.seq) {
+			return key, challstr, nil
+		}
+		select {
+		case <-ctx.Done():
+			return challstrKey{}, "", errors.WithStack(ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// setUser records the user the server says we're connected as
+func (s *state) setUser(generation uint64, user string, named bool) error {
+	return s.update(generation, func(c *connState) {
+		c.user = user
+		c.named = named
+	})
+}
+
+// setNameTaken records that the server refused the name we logged in with on the given connection
+func (s *state) setNameTaken(generation uint64, message string) error {
+	return s.update(generation, func(c *connState) {
+		c.nameTaken = cmp.Or(message, "name refused")
+	})
+}
+
+// waitLoggedIn waits until the server confirms that we're using a name we chose on the given connection, or returns
+// errStaleConnection once that connection has been replaced
+func (s *state) waitLoggedIn(ctx context.Context, generation uint64) error {
+	for {
+		s.mu.Lock()
+		current, named := s.conn.generation, s.conn.named
+		changed := s.changed
+		s.mu.Unlock()
+		if current != generation {
+			return errors.WithMessagef(errStaleConnection, "got generation %d, current is %d", generation, current)
+		}
+		if named {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// loggedIn returns whether the server confirmed that we're using a name we chose on the given connection
+func (s *state) loggedIn(generation uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.generation == generation && s.conn.named
+}
+
+// user returns the user of the current connection, and whether it's a name we chose
+func (s *state) user() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.user, s.conn.named
+}
+
+func (s *state) addRoom(generation uint64, room string) error {
+	return s.update(generation, func(c *connState) {
+		c.rooms[room] = struct{}{}
+	})
+}
+
+func (s *state) removeRoom(generation uint64, room string) error {
+	return s.update(generation, func(c *connState) {
+		delete(c.rooms, room)
+	})
+}
+
+// rooms returns the rooms joined on the current connection, sorted by ID
+func (s *state) rooms() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := make([]string, 0, len(s.conn.rooms))
+	for room := range s.conn.rooms {
+		rooms = append(rooms, room)
+	}
+	slices.Sort(rooms)
+	return rooms
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestState(t *testing.T) {
+	s := newState()
+	first := s.newConnection()
+	require.NoError(t, s.setChallstr(first, "4|first"))
+	key, challstr, err := s.waitChallstr(t.Context(), challstrKey{})
+	require.NoError(t, err)
+	require.Equal(t, "4|first", challstr)
+
+	// The server can replace the challstr at any time
+	require.NoError(t, s.setChallstr(first, "4|replaced"))
+	key, challstr, err = s.waitChallstr(t.Context(), key)
+	require.NoError(t, err)
+	require.Equal(t, "4|replaced", challstr)
+
+	require.NoError(t, s.setUser(first, "test", true))
+	require.NoError(t, s.addRoom(first, "lobby"))
+	require.NoError(t, s.addRoom(first, "battle-gen9ou-1"))
+	require.NoError(t, s.removeRoom(first, "lobby"))
+	require.Equal(t, []string{"battle-gen9ou-1"}, s.rooms())
+
+	// A new connection forgets everything from the previous one, and late messages from it are rejected
+	second := s.newConnection()
+	require.ErrorIs(t, s.setChallstr(first, "4|stale"), errStaleConnection)
+	require.Empty(t, s.rooms())
+	user, named := s.user()
+	require.Empty(t, user)
+	require.False(t, named)
+
+	waited := make(chan string)
+	go func() {
+		_, challstr, err := s.waitChallstr(t.Context(), key)
+		if err != nil {
+			t.Error(err)
+		}
+		waited <- challstr
+	}()
+	require.NoError(t, s.setChallstr(second, "4|second"))
+	require.Equal(t, "4|second", <-waited)
+
+	// Once the server refuses our name, waiting for another challstr to log in with is pointless
+	require.NoError(t, s.setNameTaken(second, "Someone is already using the name"))
+	_, _, err = s.waitChallstr(t.Context(), challstrKey{})
+	require.ErrorIs(t, err, ErrNameTaken)
+	require.ErrorContains(t, err, "Someone is already using the name")
+	s.newConnection()
+	require.NoError(t, s.setChallstr(s.newConnection(), "4|third"))
+	_, _, err = s.waitChallstr(t.Context(), challstrKey{})
+	require.NoError(t, err)
+}
@@ -7,36 +7,81 @@ import (
 
 	"gholden-go/internal/grammar"
 
-	"github.com/coder/websocket"
 	"github.com/pkg/errors"
 )
 
+// incomingMessage is a message read from the server, tagged with the generation of the connection it was read from
+type incomingMessage struct {
+	generation uint64
+	message    grammar.ServerMessage
+}
+
 type subscriber struct {
-	queue   chan<- grammar.ServerMessage
+	queue   chan<- incomingMessage
 	logger  *slog.Logger
 	timeout time.Duration
+	// readIdleTimeout is how long to wait for a message or pong before considering the connection stale, zero to
+	// wait forever
+	readIdleTimeout time.Duration
+	counts          *byteCounts
 }
 
-func newSubscriber(queue chan<- grammar.ServerMessage, logger *slog.Logger, timeout time.Duration) *subscriber {
+func newSubscriber(
+	queue chan<- incomingMessage,
+	logger *slog.Logger,
+	timeout, readIdleTimeout time.Duration,
+	counts *byteCounts,
+) *subscriber {
 	return &subscriber{
-		queue:   queue,
-		logger:  logger,
-		timeout: timeout,
+		queue:           queue,
+		logger:          logger,
+		timeout:         timeout,
+		readIdleTimeout: readIdleTimeout,
+		counts:          counts,
 	}
 }
 
-// run reads messages from the websocket, parses them into structs, and sends the structs to the queue
-func (p *subscriber) run(ctx context.Context, conn *websocket.Conn) error {
+// run reads messages from the transport, parses them into structs, and sends the structs to the queue tagged with the
+// connection's generation. Every receive on activity counts as activity on the connection, as pongs and heartbeats
+// are handled inside the transport and never returned from it.
+func (p *subscriber) run(ctx context.Context, t Transport, generation uint64, activity <-chan struct{}) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	active := func() {}
+	if p.readIdleTimeout > 0 {
+		idle := time.AfterFunc(p.readIdleTimeout, func() {
+			cancel(errors.WithMessagef(errConnectionStale, "nothing received for %s", p.readIdleTimeout))
+		})
+		defer idle.Stop()
+		active = func() { idle.Reset(p.readIdleTimeout) }
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-activity:
+					active()
+				}
+			}
+		}()
+	}
+
 	for {
-		msgType, msg, err := conn.Read(ctx)
+		msg, err := t.ReadFrame(ctx)
+		if errors.Is(err, errUnsupportedMessage) {
+			active()
+			p.logger.WarnContext(ctx, "skipping unsupported message", "error", err)
+			continue
+		}
 		if err != nil {
+			if cause := context.Cause(ctx); errors.Is(cause, errConnectionStale) {
+				return cause
+			}
 			return errors.WithStack(err)
 		}
-		p.logger.DebugContext(ctx, "message received", "type", msgType, "body", string(msg))
-		if msgType != websocket.MessageText {
-			p.logger.WarnContext(ctx, "websocket message type not supported", "message type", msgType)
-			continue
-		}
+		active()
+		p.counts.payloadRead.Add(int64(len(msg)))
+		p.logger.DebugContext(ctx, "message received", "body", string(msg))
 
 		parsed, err := grammar.ShowdownParser.Parse(msg)
 		if err != nil {
@@ -45,7 +90,7 @@ func (p *subscriber) run(ctx context.Context, conn *websocket.Conn) error {
 			continue
 		}
 		select {
-		case p.queue <- parsed:
+		case p.queue <- incomingMessage{generation: generation, message: parsed}:
 		case <-ctx.Done():
 			return errors.WithStack(ctx.Err())
 		}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"github.com/coder/websocket"
+	"github.com/pkg/errors"
+)
+
+// errUnsupportedMessage is returned by the built-in transports' ReadFrame for frames that aren't Showdown protocol
+// messages, which can be skipped
+var errUnsupportedMessage = errors.New("unsupported message")
+
+// Transport carries Showdown protocol messages over a single connection, hiding how they're framed on the wire. Each
+// frame is one message, e.g. `>lobby\n|init|chat`. ReadFrame is called from one goroutine at a time, concurrently
+// with WriteFrame and Close.
+//
+// Transports can also implement Ping(ctx) error to be kept alive with pings, and CloseNow() error to drop a broken
+// connection without trying to close it gracefully.
+type Transport interface {
+	// ReadFrame blocks until the next frame arrives. Cancelling ctx may drop the connection.
+	ReadFrame(ctx context.Context) ([]byte, error)
+	WriteFrame(ctx context.Context, frame []byte) error
+	// Close closes the connection gracefully. A ReadFrame in progress returns an error once the connection is closed.
+	Close() error
+}
+
+// pinger is implemented by transports that can check whether the server is still there
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// closeNower is implemented by transports that can drop the connection without a graceful close
+type closeNower interface {
+	CloseNow() error
+}
+
+// closeNow drops the connection if the transport supports it, and closes it gracefully otherwise
+func closeNow(t Transport) error {
+	if c, ok := t.(closeNower); ok {
+		return c.CloseNow()
+	}
+	return t.Close()
+}
+
+// Transports selectable with Config.Transport
+const (
+	transportWebsocket = "websocket"
+	// transportSockJS uses SockJS over a websocket, falling back to xhr-streaming if websockets aren't available
+	transportSockJS = "sockjs"
+	// transportXHRStreaming uses SockJS over xhr-streaming only
+	transportXHRStreaming = "xhr-streaming"
+)
+
+// compressionModes maps Config.Compression to websocket compression modes, with anything else disabling compression
+var compressionModes = map[string]websocket.CompressionMode{
+	"context-takeover":    websocket.CompressionContextTakeover,
+	"no-context-takeover": websocket.CompressionNoContextTakeover,
+}
+
+// dialTransport connects to the server with the Dial func if set, or the transport selected by Transport. Every receive on
+// activity means the server is still there, even when it's not sending messages, e.g. pongs and heartbeats.
+func (s *Session) dialTransport(ctx context.Context, activity chan<- struct{}) (Transport, error) {
+	c := s.config
+	if c.Dial != nil {
+		return c.Dial(ctx)
+	}
+	opts := &websocket.DialOptions{
+		HTTPClient:           s.websocketClient,
+		Subprotocols:         c.Subprotocols,
+		CompressionMode:      compressionModes[c.Compression],
+		CompressionThreshold: c.CompressionThreshold,
+		OnPongReceived:       func(context.Context, []byte) { notify(activity) },
+	}
+	switch c.Transport {
+	case transportSockJS:
+		t, err := dialSockJSWebsocket(ctx, c.Address, opts, c.MaxMessageSize, activity)
+		if err == nil {
+			return t, nil
+		}
+		s.logger.WarnContext(ctx, "sockjs websocket unavailable, falling back to xhr-streaming", "error", err)
+		return dialSockJSXHR(ctx, c.Address, s.httpClient, activity)
+	case transportXHRStreaming:
+		return dialSockJSXHR(ctx, c.Address, s.httpClient, activity)
+	default:
+		return dialWebsocket(ctx, c.Address, opts, c.MaxMessageSize)
+	}
+}
+
+// websocketTransport sends each Showdown message as a websocket text message, as the server's raw websocket endpoint
+// expects
+type websocketTransport struct {
+	conn *websocket.Conn
+}
+
+// dialWebsocket dials a websocket, reading messages up to readLimit bytes or the library default if zero
+func dialWebsocket(ctx context.Context, address string, opts *websocket.DialOptions, readLimit int64) (*websocketTransport, error) {
+	conn, _, err := websocket.Dial(ctx, address, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dial %s", address)
+	}
+	if readLimit > 0 {
+		conn.SetReadLimit(readLimit)
+	}
+	return &websocketTransport{conn: conn}, nil
+}
+
+func (t *websocketTransport) ReadFrame(ctx context.Context) ([]byte, error) {
+	msgType, msg, err := t.conn.Read(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if msgType != websocket.MessageText {
+		return nil, errors.WithMessagef(errUnsupportedMessage, "websocket message type %s", msgType)
+	}
+	return msg, nil
+}
+
+func (t *websocketTransport) WriteFrame(ctx context.Context, frame []byte) error {
+	return errors.WithStack(t.conn.Write(ctx, websocket.MessageText, frame))
+}
+
+func (t *websocketTransport) Ping(ctx context.Context) error {
+	return errors.WithStack(t.conn.Ping(ctx))
+}
+
+func (t *websocketTransport) Close() error {
+	return errors.WithStack(t.conn.Close(websocket.StatusNormalClosure, "closing"))
+}
+
+func (t *websocketTransport) CloseNow() error {
+	if err := t.conn.CloseNow(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_Compression(t *testing.T) {
+	doneCh := make(chan struct{})
+	helper := &loginHelper{
+		doneCh:  doneCh,
+		loginCh: make(chan loginInfo),
+	}
+	ls := httptest.NewServer(helper.loginServer(t))
+	t.Cleanup(ls.Close)
+	ws := httptest.NewServer(helper.websocketCompressed(t))
+	t.Cleanup(ws.Close)
+
+	session, err := NewSession(Config{
+		Address:         ws.URL,
+		LoginEndpoints:  []string{ls.URL},
+		ActionEndpoints: []string{ls.URL},
+		Timeout:         time.Second,
+		Compression:     "context-takeover",
+		MaxMessageSize:  1 << 20,
+	}, Credentials{}, slogt.New(t, slogt.JSON()))
+	require.NoError(t, err)
+	runInBackground(t, session.Run)
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for login")
+	}
+
+	counts := session.counts
+	// The formats list compresses to a fraction of its size, even counting the handshake
+	require.Greater(t, counts.payloadRead.Load(), int64(64<<10))
+	require.Less(t, counts.wireRead.Load(), counts.payloadRead.Load()/4)
+	require.NotZero(t, counts.payloadWritten.Load())
+	require.NotZero(t, counts.wireWritten.Load())
+}
+
+// websocketCompressed is like websocketLogin with compression enabled, and sends a large formats list before the
+// challstr
+func (h *loginHelper) websocketCompressed(t *testing.T) http.HandlerFunc {
+	t.Helper()
+	opts := &websocket.AcceptOptions{CompressionMode: websocket.CompressionContextTakeover}
+	formats := "|formats|" + strings.Repeat(",1|S/V Singles|[Gen 9] Random Battle,f|[Gen 9] OU,e", 2000)
+	return h.websocketServer(t, opts, []string{formats}, func(*websocket.Conn, loginInfo) {
+		close(h.doneCh)
+	})
+}
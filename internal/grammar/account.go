@@ -0,0 +1,180 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Avatar changes the trainer sprite, either by name (e.g. "dawn") or by number
+type Avatar struct {
+	Avatar string // required
+}
+
+func (a Avatar) Validate() error {
+	if escapeText(a.Avatar) == "" {
+		return errors.New("avatar is required")
+	}
+	return nil
+}
+
+func (a Avatar) Serialize() (string, error) {
+	return serialize(a)
+}
+
+func (a Avatar) command() string {
+	return fmt.Sprintf("|/avatar %s", escapeText(a.Avatar))
+}
+
+// Status sets the custom status shown on the user's profile
+type Status struct {
+	Message string // required
+}
+
+func (s Status) Validate() error {
+	if escapeText(s.Message) == "" {
+		return errors.New("status message is required")
+	}
+	return nil
+}
+
+func (s Status) Serialize() (string, error) {
+	return serialize(s)
+}
+
+func (s Status) command() string {
+	return fmt.Sprintf("|/status %s", escapeText(s.Message))
+}
+
+type Away struct {
+	// Message can be left empty to use the default away status
+	Message string
+}
+
+func (a Away) Serialize() (string, error) {
+	return serialize(a)
+}
+
+func (a Away) command() string {
+	if msg := escapeText(a.Message); msg != "" {
+		return fmt.Sprintf("|/away %s", msg)
+	}
+	return "|/away"
+}
+
+// Back clears an away status
+type Back struct{}
+
+func (b Back) Serialize() (string, error) {
+	return serialize(b)
+}
+
+func (b Back) command() string {
+	return "|/back"
+}
+
+type BlockChallenges struct{}
+
+func (b BlockChallenges) Serialize() (string, error) {
+	return serialize(b)
+}
+
+func (b BlockChallenges) command() string {
+	return "|/blockchallenges"
+}
+
+type AllowChallenges struct{}
+
+func (a AllowChallenges) Serialize() (string, error) {
+	return serialize(a)
+}
+
+func (a AllowChallenges) command() string {
+	return "|/allowchallenges"
+}
+
+type BlockPMs struct {
+	// Group can be left empty to block PMs from everyone, or set to a rank symbol (e.g. "+") to only allow PMs
+	// from users at or above that rank
+	Group string
+}
+
+func (b BlockPMs) Serialize() (string, error) {
+	return serialize(b)
+}
+
+func (b BlockPMs) command() string {
+	if group := escapeText(b.Group); group != "" {
+		return fmt.Sprintf("|/blockpms %s", group)
+	}
+	return "|/blockpms"
+}
+
+type Ignore struct {
+	User string // required
+}
+
+func (i Ignore) Validate() error {
+	return validateUser(i.User)
+}
+
+func (i Ignore) Serialize() (string, error) {
+	return serialize(i)
+}
+
+func (i Ignore) command() string {
+	return fmt.Sprintf("|/ignore %s", ToID(i.User))
+}
+
+type Unignore struct {
+	User string // required
+}
+
+func (u Unignore) Validate() error {
+	return validateUser(u.User)
+}
+
+func (u Unignore) Serialize() (string, error) {
+	return serialize(u)
+}
+
+func (u Unignore) command() string {
+	return fmt.Sprintf("|/unignore %s", ToID(u.User))
+}
+
+type QueryType string
+
+const (
+	QueryUserDetails QueryType = "userdetails"
+	QueryRoomList    QueryType = "roomlist"
+	QueryRooms       QueryType = "rooms"
+	QueryLadderTop   QueryType = "laddertop"
+)
+
+// Query asks the server for information, which is answered with a `|queryresponse|QUERYTYPE|JSON` message
+type Query struct {
+	Type QueryType // required
+	// Arg is the subject of the query, e.g. the user for QueryUserDetails or the format for QueryLadderTop
+	Arg string
+}
+
+func (q Query) Validate() error {
+	switch q.Type {
+	case QueryUserDetails, QueryRoomList, QueryRooms, QueryLadderTop:
+	case "":
+		return errors.New("query type is required")
+	default:
+		return fmt.Errorf("unknown query type %q", q.Type)
+	}
+	return nil
+}
+
+func (q Query) Serialize() (string, error) {
+	return serialize(q)
+}
+
+func (q Query) command() string {
+	if arg := escapeArg(q.Arg); arg != "" {
+		return fmt.Sprintf("|/cmd %s %s", q.Type, arg)
+	}
+	return fmt.Sprintf("|/cmd %s", q.Type)
+}
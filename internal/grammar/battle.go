@@ -0,0 +1,257 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Choose answers a `|request|` in a battle room with one decision per active slot, e.g.
+// `battle-gen9ou-1|/choose move 1 terastallize, switch 3|4`
+type Choose struct {
+	Room string // required
+	// Choices holds one decision per active slot, in slot order. Singles battles have exactly one,
+	// doubles and triples have one per active pokemon.
+	Choices []Choice // required
+	// RequestID is the rqid of the request being answered. Zero omits the `|RQID` suffix.
+	RequestID int
+}
+
+func (c Choose) Validate() error {
+	if err := validateRoom(c.Room); err != nil {
+		return err
+	}
+	if len(c.Choices) == 0 {
+		return errors.New("at least one choice is required")
+	}
+	for i, choice := range c.Choices {
+		if choice == nil {
+			return fmt.Errorf("choice %d is nil", i+1)
+		}
+		if _, ok := choice.(DefaultChoice); ok && len(c.Choices) > 1 {
+			return errors.New("default cannot be combined with other choices")
+		}
+		if err := choice.validate(); err != nil {
+			return fmt.Errorf("invalid choice %d: %w", i+1, err)
+		}
+	}
+	if c.RequestID < 0 {
+		return fmt.Errorf("invalid request id %d", c.RequestID)
+	}
+	return nil
+}
+
+func (c Choose) Serialize() (string, error) {
+	return serialize(c)
+}
+
+func (c Choose) command() string {
+	choices := make([]string, 0, len(c.Choices))
+	for _, choice := range c.Choices {
+		choices = append(choices, choice.choice())
+	}
+	return withRequestID(fmt.Sprintf("%s|/choose %s", c.Room, strings.Join(choices, ", ")), c.RequestID)
+}
+
+// Choice is a decision for a single active slot, to be sent as part of Choose
+type Choice interface {
+	choice() string
+	validate() error
+}
+
+type MoveModifier string
+
+const (
+	Terastallize MoveModifier = "terastallize"
+	Mega         MoveModifier = "mega"
+	ZMove        MoveModifier = "zmove"
+	Dynamax      MoveModifier = "dynamax"
+)
+
+// MoveChoice uses a move, picked either by its slot or by its name
+type MoveChoice struct {
+	// Slot is the 1-indexed move slot. Exactly one of Slot and Name must be set.
+	Slot int
+	Name string
+	// Target is only needed in doubles and triples: 1 to 3 for foes, -1 to -3 for allies. Zero omits it.
+	Target   int
+	Modifier MoveModifier
+}
+
+func (m MoveChoice) choice() string {
+	b := strings.Builder{}
+	b.WriteString("move ")
+	if m.Slot != 0 {
+		b.WriteString(strconv.Itoa(m.Slot))
+	} else {
+		b.WriteString(m.Name)
+	}
+	if m.Target != 0 {
+		b.WriteString(fmt.Sprintf(" %d", m.Target))
+	}
+	if m.Modifier != "" {
+		b.WriteString(fmt.Sprintf(" %s", m.Modifier))
+	}
+	return b.String()
+}
+
+func (m MoveChoice) validate() error {
+	switch {
+	case m.Slot != 0 && m.Name != "":
+		return errors.New("move slot and name are mutually exclusive")
+	case m.Slot == 0 && m.Name == "":
+		return errors.New("move slot or name is required")
+	case m.Slot < 0 || m.Slot > 4:
+		return fmt.Errorf("move slot %d out of range [1, 4]", m.Slot)
+	case strings.ContainsAny(m.Name, choiceReserved):
+		return fmt.Errorf("move name %q contains reserved characters", m.Name)
+	case m.Target < -3 || m.Target > 3:
+		return fmt.Errorf("move target %d out of range [-3, 3]", m.Target)
+	}
+	switch m.Modifier {
+	case "", Terastallize, Mega, ZMove, Dynamax:
+	default:
+		return fmt.Errorf("unknown move modifier %q", m.Modifier)
+	}
+	return nil
+}
+
+// SwitchChoice switches in a benched pokemon, picked either by its team position or by its name
+type SwitchChoice struct {
+	// Slot is the 1-indexed team position. Exactly one of Slot and Name must be set.
+	Slot int
+	Name string
+}
+
+func (s SwitchChoice) choice() string {
+	if s.Slot != 0 {
+		return fmt.Sprintf("switch %d", s.Slot)
+	}
+	return fmt.Sprintf("switch %s", s.Name)
+}
+
+func (s SwitchChoice) validate() error {
+	switch {
+	case s.Slot != 0 && s.Name != "":
+		return errors.New("switch slot and name are mutually exclusive")
+	case s.Slot == 0 && s.Name == "":
+		return errors.New("switch slot or name is required")
+	case s.Slot < 0 || s.Slot > 6:
+		return fmt.Errorf("switch slot %d out of range [1, 6]", s.Slot)
+	case strings.ContainsAny(s.Name, choiceReserved):
+		return fmt.Errorf("switch name %q contains reserved characters", s.Name)
+	}
+	return nil
+}
+
+// PassChoice skips a slot that has nothing to do, e.g. a fainted pokemon in doubles with no replacement
+type PassChoice struct{}
+
+func (PassChoice) choice() string  { return "pass" }
+func (PassChoice) validate() error { return nil }
+
+// DefaultChoice lets the server pick; it must be the only choice sent
+type DefaultChoice struct{}
+
+func (DefaultChoice) choice() string  { return "default" }
+func (DefaultChoice) validate() error { return nil }
+
+// TeamOrder answers a team preview request with the order to bring pokemon in, e.g.
+// `battle-gen9vgc2025-1|/team 3142|2`
+type TeamOrder struct {
+	Room string // required
+	// Order lists 1-indexed team positions; the first ones listed lead
+	Order     []int // required
+	RequestID int
+}
+
+func (t TeamOrder) Validate() error {
+	if err := validateRoom(t.Room); err != nil {
+		return err
+	}
+	if len(t.Order) == 0 {
+		return errors.New("team order is required")
+	}
+	seen := map[int]bool{}
+	for _, pos := range t.Order {
+		if pos < 1 || pos > 24 {
+			return fmt.Errorf("team position %d out of range [1, 24]", pos)
+		}
+		if seen[pos] {
+			return fmt.Errorf("team position %d listed more than once", pos)
+		}
+		seen[pos] = true
+	}
+	if t.RequestID < 0 {
+		return fmt.Errorf("invalid request id %d", t.RequestID)
+	}
+	return nil
+}
+
+func (t TeamOrder) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TeamOrder) command() string {
+	// Showdown accepts a bare string of digits, but needs commas once positions have two digits
+	sep := ""
+	positions := make([]string, 0, len(t.Order))
+	for _, pos := range t.Order {
+		if pos > 9 {
+			sep = ","
+		}
+		positions = append(positions, strconv.Itoa(pos))
+	}
+	return withRequestID(fmt.Sprintf("%s|/team %s", t.Room, strings.Join(positions, sep)), t.RequestID)
+}
+
+// Undo takes back the last decision sent to a battle room, as long as the turn hasn't started
+type Undo struct {
+	Room string // required
+}
+
+func (u Undo) Validate() error {
+	return validateRoom(u.Room)
+}
+
+func (u Undo) Serialize() (string, error) {
+	return serialize(u)
+}
+
+func (u Undo) command() string {
+	return fmt.Sprintf("%s|/undo", u.Room)
+}
+
+// choiceReserved are the characters that would split a choice, or the whole message, in two
+const choiceReserved = ",|\r\n"
+
+func validateRoom(room string) error {
+	if room == "" {
+		return errors.New("room is required")
+	}
+	if strings.ContainsAny(room, "|\r\n ") {
+		return fmt.Errorf("room %q contains reserved characters", room)
+	}
+	return nil
+}
+
+// validateUser checks a user that's sent as an ID. Reserved characters are rejected rather than dropped by ToID, as
+// they can only come from input smuggling in another argument or command, and dropping them would turn the user into
+// someone else.
+func validateUser(user string) error {
+	if strings.ContainsAny(user, ",|\r\n") {
+		return fmt.Errorf("user %q contains reserved characters", user)
+	}
+	if ToID(user) == "" {
+		return errors.New("user is required")
+	}
+	return nil
+}
+
+func withRequestID(msg string, rqid int) string {
+	if rqid == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s|%d", msg, rqid)
+}
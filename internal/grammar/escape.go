@@ -0,0 +1,36 @@
+package grammar
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	newlines       = regexp.MustCompile(`[\r\n]+`)
+	nonIDChars     = regexp.MustCompile(`[^a-z0-9]+`)
+	nonRoomIDChars = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// escapeText makes free text safe to send as the last argument of a command. Showdown treats every line of a
+// message as a separate command, so newlines are collapsed into spaces.
+func escapeText(s string) string {
+	return strings.TrimSpace(newlines.ReplaceAllString(s, " "))
+}
+
+// escapeArg makes text safe to send as one argument of a comma separated command, e.g. `/challenge user, format`.
+// Showdown has no way to quote a comma, so they're dropped along with newlines.
+func escapeArg(s string) string {
+	return escapeText(strings.ReplaceAll(s, ",", ""))
+}
+
+// ToID converts a username or format name to the ID Showdown uses for it, e.g. "Zarel" -> "zarel" and
+// "[Gen 9] OU" -> "gen9ou"
+func ToID(s string) string {
+	return nonIDChars.ReplaceAllString(strings.ToLower(s), "")
+}
+
+// toRoomID converts a room title to its ID, e.g. "Lobby" -> "lobby". Unlike user IDs, room IDs keep their
+// hyphens, e.g. "battle-gen9ou-1".
+func toRoomID(s string) string {
+	return nonRoomIDChars.ReplaceAllString(strings.ToLower(s), "")
+}
@@ -0,0 +1,115 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Search joins the ladder queue for a format. The team set by UseTeam is used for formats that need one.
+type Search struct {
+	Format string // required
+}
+
+func (s Search) Validate() error {
+	if ToID(s.Format) == "" {
+		return errors.New("format is required")
+	}
+	return nil
+}
+
+func (s Search) Serialize() (string, error) {
+	return serialize(s)
+}
+
+func (s Search) command() string {
+	return fmt.Sprintf("|/search %s", ToID(s.Format))
+}
+
+// CancelSearch leaves every ladder queue
+type CancelSearch struct{}
+
+func (c CancelSearch) Serialize() (string, error) {
+	return serialize(c)
+}
+
+func (c CancelSearch) command() string {
+	return "|/cancelsearch"
+}
+
+// Accept accepts a pending challenge from User, using the team set by UseTeam
+type Accept struct {
+	User string // required
+}
+
+func (a Accept) Validate() error {
+	return validateUser(a.User)
+}
+
+func (a Accept) Serialize() (string, error) {
+	return serialize(a)
+}
+
+func (a Accept) command() string {
+	return fmt.Sprintf("|/accept %s", ToID(a.User))
+}
+
+type Reject struct {
+	// User can be left empty to reject every pending challenge
+	User string
+}
+
+func (r Reject) Validate() error {
+	if r.User == "" {
+		return nil
+	}
+	return validateUser(r.User)
+}
+
+func (r Reject) Serialize() (string, error) {
+	return serialize(r)
+}
+
+func (r Reject) command() string {
+	if user := ToID(r.User); user != "" {
+		return fmt.Sprintf("|/reject %s", user)
+	}
+	return "|/reject"
+}
+
+// CancelChallenge withdraws a challenge previously sent to User
+type CancelChallenge struct {
+	User string // required
+}
+
+func (c CancelChallenge) Validate() error {
+	return validateUser(c.User)
+}
+
+func (c CancelChallenge) Serialize() (string, error) {
+	return serialize(c)
+}
+
+func (c CancelChallenge) command() string {
+	return fmt.Sprintf("|/cancelchallenge %s", ToID(c.User))
+}
+
+// UseTeam sets the team used by the next Search, Challenge or Accept
+type UseTeam struct {
+	// Team can be left empty for formats that generate teams, e.g. random battles
+	Team Team
+}
+
+func (u UseTeam) Validate() error {
+	return u.Team.Validate()
+}
+
+func (u UseTeam) Serialize() (string, error) {
+	return serialize(u)
+}
+
+func (u UseTeam) command() string {
+	if len(u.Team) == 0 {
+		return "|/utm null"
+	}
+	return fmt.Sprintf("|/utm %s", u.Team.Pack())
+}
@@ -37,13 +37,22 @@ type ServerMessage struct {
 	Lines []*Line `(@@ EOL?)+`
 }
 
+// Room returns the room the message was sent to, or an empty string for global messages. The room is only named on
+// the first line of a message, and applies to every line after it.
+func (m ServerMessage) Room() string {
+	if len(m.Lines) == 0 || m.Lines[0].RoomID == nil {
+		return ""
+	}
+	return m.Lines[0].RoomID.Room
+}
+
 type Line struct {
 	RoomID  *RoomID  `@@?`
 	Message *Message `@@`
 }
 
 type RoomID struct {
-	Room string `Room @Ident`
+	Room string `Room @(Ident | String)+ EOL`
 }
 
 type Message struct {
@@ -56,9 +65,10 @@ type ChallstrMessage struct {
 	Challstr string `@String`
 }
 
+// UnknownMessage captures everything after the command as is, e.g. `|init|battle` or `|deinit`
 type UnknownMessage struct {
-	Command string `Sep @Ident Sep`
-	Data    string `@String`
+	Command string `Sep @Ident?`
+	Data    string `(Sep? @(Ident | String | Sep | Room)+)?`
 }
 
 type parser struct {
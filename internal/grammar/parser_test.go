@@ -74,6 +74,42 @@ func Test_parser_Parse(t *testing.T) {
 				}},
 			}},
 		},
+		{
+			name: "room",
+			data: []byte(">battle-gen9randombattle-1\n|init|battle\n|title|Zarel vs. Hoeenhero\n|j|☆Zarel\n|\n|t:|1766374653"),
+			want: ServerMessage{Lines: []*Line{
+				{
+					RoomID: &RoomID{Room: "battle-gen9randombattle-1"},
+					Message: &Message{
+						UnknownMessage: &UnknownMessage{Command: "init", Data: "battle"},
+					},
+				},
+				{Message: &Message{
+					UnknownMessage: &UnknownMessage{Command: "title", Data: "Zarel vs. Hoeenhero"},
+				}},
+				{Message: &Message{
+					UnknownMessage: &UnknownMessage{Command: "j", Data: "☆Zarel"},
+				}},
+				{Message: &Message{
+					UnknownMessage: &UnknownMessage{},
+				}},
+				{Message: &Message{
+					UnknownMessage: &UnknownMessage{Command: "t", Data: ":|1766374653"},
+				}},
+			}},
+		},
+		{
+			name: "deinit",
+			data: []byte(">lobby\n|deinit"),
+			want: ServerMessage{Lines: []*Line{
+				{
+					RoomID: &RoomID{Room: "lobby"},
+					Message: &Message{
+						UnknownMessage: &UnknownMessage{Command: "deinit"},
+					},
+				},
+			}},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
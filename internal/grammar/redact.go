@@ -0,0 +1,70 @@
+package grammar
+
+import "strings"
+
+// Redacted replaces secrets in redacted messages
+const Redacted = "[REDACTED]"
+
+// Redactable is implemented by messages that can carry secrets, e.g. login assertions and challstrs. Redact returns a
+// copy of the message with the secrets replaced by Redacted, which is safe to log.
+type Redactable interface {
+	Redact() any
+}
+
+func (r Rename) Redact() any {
+	r.Assertion = Redacted
+	return r
+}
+
+// Redact hides the assertion of /trn commands sent as is
+func (r RawCommand) Redact() any {
+	r.Command = redactRename(r.Command)
+	return r
+}
+
+// redactRename replaces the assertion in a `/trn USERNAME,0,ASSERTION` command
+func redactRename(command string) string {
+	trimmed := strings.TrimPrefix(command, Separator)
+	if !strings.HasPrefix(trimmed, "/trn ") {
+		return command
+	}
+	name, rest, ok := strings.Cut(command, ",")
+	if !ok {
+		return command
+	}
+	registered, _, ok := strings.Cut(rest, ",")
+	if !ok {
+		return command
+	}
+	return name + "," + registered + "," + Redacted
+}
+
+func (m ServerMessage) Redact() any {
+	lines := make([]*Line, len(m.Lines))
+	for i, line := range m.Lines {
+		lines[i] = line.redact()
+	}
+	return ServerMessage{Lines: lines}
+}
+
+func (l *Line) Redact() any {
+	return l.redact()
+}
+
+func (l *Line) redact() *Line {
+	if l == nil {
+		return nil
+	}
+	return &Line{RoomID: l.RoomID, Message: l.Message.redact()}
+}
+
+func (m *Message) Redact() any {
+	return m.redact()
+}
+
+func (m *Message) redact() *Message {
+	if m == nil || m.ChallstrMessage == nil {
+		return m
+	}
+	return &Message{ChallstrMessage: &ChallstrMessage{Command: m.ChallstrMessage.Command, Challstr: Redacted}}
+}
@@ -0,0 +1,64 @@
+package grammar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactable_Redact(t *testing.T) {
+	challstr, err := ShowdownParser.Parse([]byte("|challstr|4|abc"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		msg  Redactable
+		want any
+	}{
+		{
+			name: "rename",
+			msg:  Rename{Username: "Some User", Assertion: "abc,zarel,2,1766374653,sim3.psim.us"},
+			want: Rename{Username: "Some User", Assertion: Redacted},
+		},
+		{
+			name: "raw rename",
+			msg:  RawCommand{Command: "|/trn Some User,0,abc,zarel,2,1766374653,sim3.psim.us"},
+			want: RawCommand{Command: "|/trn Some User,0," + Redacted},
+		},
+		{
+			name: "raw rename without assertion",
+			msg:  RawCommand{Command: "/trn Some User"},
+			want: RawCommand{Command: "/trn Some User"},
+		},
+		{
+			name: "other raw command",
+			msg:  RawCommand{Command: "|/join lobby,0,x"},
+			want: RawCommand{Command: "|/join lobby,0,x"},
+		},
+		{
+			name: "challstr",
+			msg:  challstr,
+			want: ServerMessage{Lines: []*Line{{Message: &Message{
+				ChallstrMessage: &ChallstrMessage{Challstr: Redacted},
+			}}}},
+		},
+		{
+			name: "challstr line",
+			msg:  challstr.Lines[0],
+			want: &Line{Message: &Message{ChallstrMessage: &ChallstrMessage{Challstr: Redacted}}},
+		},
+		{
+			name: "other message",
+			msg:  &Message{UnknownMessage: &UnknownMessage{Command: "init", Data: "battle"}},
+			want: &Message{UnknownMessage: &UnknownMessage{Command: "init", Data: "battle"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.msg.Redact())
+		})
+	}
+
+	// The original is left as is
+	require.Equal(t, "4|abc", challstr.Lines[0].Message.ChallstrMessage.Challstr)
+}
@@ -0,0 +1,98 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Join struct {
+	Room string // required
+}
+
+func (j Join) Validate() error {
+	if toRoomID(j.Room) == "" {
+		return errors.New("room is required")
+	}
+	return nil
+}
+
+func (j Join) Serialize() (string, error) {
+	return serialize(j)
+}
+
+func (j Join) command() string {
+	return fmt.Sprintf("|/join %s", toRoomID(j.Room))
+}
+
+type Leave struct {
+	Room string // required
+}
+
+func (l Leave) Validate() error {
+	if toRoomID(l.Room) == "" {
+		return errors.New("room is required")
+	}
+	return nil
+}
+
+func (l Leave) Serialize() (string, error) {
+	return serialize(l)
+}
+
+func (l Leave) command() string {
+	return fmt.Sprintf("|/leave %s", toRoomID(l.Room))
+}
+
+// SaveReplay uploads the replay of a finished battle
+type SaveReplay struct {
+	Room string // required
+}
+
+func (s SaveReplay) Validate() error {
+	return validateRoom(s.Room)
+}
+
+func (s SaveReplay) Serialize() (string, error) {
+	return serialize(s)
+}
+
+func (s SaveReplay) command() string {
+	return fmt.Sprintf("%s|/savereplay", s.Room)
+}
+
+// Timer turns the battle timer in Room on or off
+type Timer struct {
+	Room string // required
+	On   bool
+}
+
+func (t Timer) Validate() error {
+	return validateRoom(t.Room)
+}
+
+func (t Timer) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t Timer) command() string {
+	if t.On {
+		return fmt.Sprintf("%s|/timer on", t.Room)
+	}
+	return fmt.Sprintf("%s|/timer off", t.Room)
+}
+
+type Forfeit struct {
+	Room string // required
+}
+
+func (f Forfeit) Validate() error {
+	return validateRoom(f.Room)
+}
+
+func (f Forfeit) Serialize() (string, error) {
+	return serialize(f)
+}
+
+func (f Forfeit) command() string {
+	return fmt.Sprintf("%s|/forfeit", f.Room)
+}
@@ -1,21 +1,76 @@
 package grammar
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"unicode/utf8"
 )
 
 // TODO maybe this should go in a different package
 type ClientMessage interface {
-	Serialize() string
+	// Serialize returns the message as it should be written to the socket, or an error if the message is invalid
+	Serialize() (string, error)
 }
 
+// Validator is implemented by ClientMessages that can be malformed. It lets callers check a message before queueing
+// it; Serialize runs the same checks.
+type Validator interface {
+	Validate() error
+}
+
+// command is the unchecked serialized form of a ClientMessage
+type command interface {
+	command() string
+}
+
+// serialize validates and serializes every ClientMessage. Showdown runs each line of a socket message as a separate
+// command, so whatever ends up in the fields, the serialized message can never span more than one line.
+func serialize(c command) (string, error) {
+	if v, ok := c.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return "", fmt.Errorf("invalid %T: %w", c, err)
+		}
+	}
+	s := c.command()
+	if strings.ContainsAny(s, "\r\n") {
+		return "", fmt.Errorf("invalid %T: %w", c, ErrMultipleLines)
+	}
+	return s, nil
+}
+
+var ErrMultipleLines = errors.New("message spans multiple lines")
+
+// maxUsernameLength is the longest name Showdown accepts
+const maxUsernameLength = 18
+
 type Rename struct {
-	Username  string
-	Assertion string
+	// Username is the display name, which unlike most other commands isn't converted to an ID
+	Username  string // required
+	Assertion string // required
 }
 
-func (r Rename) Serialize() string {
+func (r Rename) Validate() error {
+	switch {
+	case ToID(r.Username) == "":
+		return errors.New("username is required")
+	case utf8.RuneCountInString(r.Username) > maxUsernameLength:
+		return fmt.Errorf("username %q is longer than %d characters", r.Username, maxUsernameLength)
+	case strings.ContainsAny(r.Username, ",|\r\n"):
+		return fmt.Errorf("username %q contains reserved characters", r.Username)
+	case r.Assertion == "":
+		return errors.New("assertion is required")
+	case strings.ContainsAny(r.Assertion, "\r\n"):
+		return errors.New("assertion contains a newline")
+	}
+	return nil
+}
+
+func (r Rename) Serialize() (string, error) {
+	return serialize(r)
+}
+
+func (r Rename) command() string {
 	return fmt.Sprintf("|/trn %s,0,%s", r.Username, r.Assertion)
 }
 
@@ -24,28 +79,45 @@ type Help struct {
 	Command string
 }
 
-func (h Help) Serialize() string {
-	return fmt.Sprintf("|/help %s", h.Command)
+func (h Help) Serialize() (string, error) {
+	return serialize(h)
+}
+
+func (h Help) command() string {
+	return fmt.Sprintf("|/help %s", escapeText(h.Command))
 }
 
 type Challenge struct {
-	User   string
+	User   string // required
 	Format string
 }
 
-func (c Challenge) Serialize() string {
+func (c Challenge) Validate() error {
+	return validateUser(c.User)
+}
+
+func (c Challenge) Serialize() (string, error) {
+	return serialize(c)
+}
+
+func (c Challenge) command() string {
 	b := strings.Builder{}
-	b.WriteString(fmt.Sprintf("|/challenge %s", c.User))
-	if c.Format != "" {
-		b.WriteString(fmt.Sprintf(", %s", c.Format))
+	b.WriteString(fmt.Sprintf("|/challenge %s", ToID(c.User)))
+	if format := ToID(c.Format); format != "" {
+		b.WriteString(fmt.Sprintf(", %s", format))
 	}
 	return b.String()
 }
 
+// RawCommand is sent as is, apart from the check that it's a single line
 type RawCommand struct {
 	Command string
 }
 
-func (r RawCommand) Serialize() string {
+func (r RawCommand) Serialize() (string, error) {
+	return serialize(r)
+}
+
+func (r RawCommand) command() string {
 	return r.Command
 }
@@ -0,0 +1,434 @@
+package grammar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientMessage_Serialize(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  ClientMessage
+		want string
+	}{
+		{
+			name: "move by slot",
+			msg: Choose{
+				Room:      "battle-gen9randombattle-1",
+				Choices:   []Choice{MoveChoice{Slot: 1}},
+				RequestID: 3,
+			},
+			want: "battle-gen9randombattle-1|/choose move 1|3",
+		},
+		{
+			name: "move by name with modifier",
+			msg: Choose{
+				Room:    "battle-gen9ou-1",
+				Choices: []Choice{MoveChoice{Name: "Tera Blast", Modifier: Terastallize}},
+			},
+			want: "battle-gen9ou-1|/choose move Tera Blast terastallize",
+		},
+		{
+			name: "doubles",
+			msg: Choose{
+				Room: "battle-gen9vgc2025regj-1",
+				Choices: []Choice{
+					MoveChoice{Slot: 2, Target: -1, Modifier: Dynamax},
+					SwitchChoice{Slot: 4},
+				},
+				RequestID: 12,
+			},
+			want: "battle-gen9vgc2025regj-1|/choose move 2 -1 dynamax, switch 4|12",
+		},
+		{
+			name: "triples with pass",
+			msg: Choose{
+				Room:    "battle-gen5triplescustomgame-1",
+				Choices: []Choice{PassChoice{}, SwitchChoice{Name: "Garchomp"}, MoveChoice{Slot: 3, Target: 2}},
+			},
+			want: "battle-gen5triplescustomgame-1|/choose pass, switch Garchomp, move 3 2",
+		},
+		{
+			name: "default",
+			msg:  Choose{Room: "battle-gen9ou-1", Choices: []Choice{DefaultChoice{}}, RequestID: 1},
+			want: "battle-gen9ou-1|/choose default|1",
+		},
+		{
+			name: "team order",
+			msg:  TeamOrder{Room: "battle-gen9vgc2025regj-1", Order: []int{3, 1, 4, 2}, RequestID: 1},
+			want: "battle-gen9vgc2025regj-1|/team 3142|1",
+		},
+		{
+			name: "team order with two digit positions",
+			msg:  TeamOrder{Room: "battle-gen9customgame-1", Order: []int{12, 1}},
+			want: "battle-gen9customgame-1|/team 12,1",
+		},
+		{
+			name: "undo",
+			msg:  Undo{Room: "battle-gen9ou-1"},
+			want: "battle-gen9ou-1|/undo",
+		},
+		{
+			name: "rename",
+			msg:  Rename{Username: "Some User", Assertion: "abc,zarel,2,1766374653,sim3.psim.us"},
+			want: "|/trn Some User,0,abc,zarel,2,1766374653,sim3.psim.us",
+		},
+		{
+			// 18 characters, but more bytes
+			name: "rename with a non-ASCII name",
+			msg:  Rename{Username: "Pokémon Trainer Ré", Assertion: "abc"},
+			want: "|/trn Pokémon Trainer Ré,0,abc",
+		},
+		{
+			name: "challenge normalizes user and format",
+			msg:  Challenge{User: "Zarel!", Format: "[Gen 9] OU"},
+			want: "|/challenge zarel, gen9ou",
+		},
+		{
+			name: "search",
+			msg:  Search{Format: "[Gen 9] Random Battle"},
+			want: "|/search gen9randombattle",
+		},
+		{
+			name: "cancel search",
+			msg:  CancelSearch{},
+			want: "|/cancelsearch",
+		},
+		{
+			name: "accept",
+			msg:  Accept{User: "zarel"},
+			want: "|/accept zarel",
+		},
+		{
+			name: "reject everyone",
+			msg:  Reject{},
+			want: "|/reject",
+		},
+		{
+			name: "cancel challenge",
+			msg:  CancelChallenge{User: "zarel"},
+			want: "|/cancelchallenge zarel",
+		},
+		{
+			name: "use team",
+			msg: UseTeam{Team: Team{{
+				Species: "Garchomp",
+				Item:    "Choice Scarf",
+				Ability: "Rough Skin",
+				Moves:   []string{"Earthquake", "Outrage"},
+				Nature:  "Jolly",
+				EVs:     &Stats{Atk: 252, SpD: 4, Spe: 252},
+			}}},
+			want: "|/utm Garchomp||ChoiceScarf|RoughSkin|Earthquake,Outrage|Jolly|,252,,,4,252|||||",
+		},
+		{
+			name: "use no team",
+			msg:  UseTeam{},
+			want: "|/utm null",
+		},
+		{
+			name: "join by title",
+			msg:  Join{Room: "Tournaments"},
+			want: "|/join tournaments",
+		},
+		{
+			name: "leave battle",
+			msg:  Leave{Room: "battle-gen9ou-1"},
+			want: "|/leave battle-gen9ou-1",
+		},
+		{
+			name: "timer on",
+			msg:  Timer{Room: "battle-gen9ou-1", On: true},
+			want: "battle-gen9ou-1|/timer on",
+		},
+		{
+			name: "forfeit",
+			msg:  Forfeit{Room: "battle-gen9ou-1"},
+			want: "battle-gen9ou-1|/forfeit",
+		},
+		{
+			name: "save replay",
+			msg:  SaveReplay{Room: "battle-gen9ou-1"},
+			want: "battle-gen9ou-1|/savereplay",
+		},
+		{
+			name: "status with newlines",
+			msg:  Status{Message: "brb\n/forfeit\r\nlol"},
+			want: "|/status brb /forfeit lol",
+		},
+		{
+			name: "away without message",
+			msg:  Away{},
+			want: "|/away",
+		},
+		{
+			name: "block pms below voice",
+			msg:  BlockPMs{Group: "+"},
+			want: "|/blockpms +",
+		},
+		{
+			name: "ignore normalizes user",
+			msg:  Ignore{User: "Some User Jr."},
+			want: "|/ignore someuserjr",
+		},
+		{
+			name: "query with comma",
+			msg:  Query{Type: QueryUserDetails, Arg: "zarel,\nstaff"},
+			want: "|/cmd userdetails zarel staff",
+		},
+		{
+			name: "query without arg",
+			msg:  Query{Type: QueryRooms},
+			want: "|/cmd rooms",
+		},
+		{
+			name: "new tournament",
+			msg:  TourNew{Room: "tournaments", Format: "gen9ou", Type: Elimination, PlayerCap: 64, Rounds: 2, Name: "Monthly, OU"},
+			want: "tournaments|/tour new gen9ou, elimination, 64, 2, Monthly OU",
+		},
+		{
+			name: "new tournament with defaults",
+			msg:  TourNew{Room: "tournaments", Format: "gen9ou", Type: RoundRobin, Name: "Round Robin"},
+			want: "tournaments|/tour new gen9ou, roundrobin, 0, 1, Round Robin",
+		},
+		{
+			name: "join tournament",
+			msg:  TourJoin{Room: "tournaments"},
+			want: "tournaments|/tour join",
+		},
+		{
+			name: "tournament challenge",
+			msg:  TourChallenge{Room: "tournaments", User: "Zarel"},
+			want: "tournaments|/tour challenge zarel",
+		},
+		{
+			name: "tournament autostart",
+			msg:  TourAutoStart{Room: "tournaments", Timeout: 90 * time.Second},
+			want: "tournaments|/tour autostart 1.5",
+		},
+		{
+			name: "tournament autodq off",
+			msg:  TourAutoDQ{Room: "tournaments"},
+			want: "tournaments|/tour autodq off",
+		},
+		{
+			name: "tournament rules",
+			msg:  TourRules{Room: "tournaments", Rules: []string{"-Garchomp", "Sleep Clause Mod"}},
+			want: "tournaments|/tour rules -Garchomp, Sleep Clause Mod",
+		},
+		{
+			name: "tournament settype",
+			msg:  TourSetType{Room: "tournaments", Type: Elimination, Rounds: 2},
+			want: "tournaments|/tour settype elimination, 2",
+		},
+		{
+			name: "tournament forcetimer",
+			msg:  TourForceTimer{Room: "tournaments", On: true},
+			want: "tournaments|/tour forcetimer on",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.msg.Serialize()
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestClientMessage_SerializeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     ClientMessage
+		wantErr string
+	}{
+		{
+			name:    "rename smuggling a second command",
+			msg:     Rename{Username: "zarel\n|/forfeit", Assertion: "abc"},
+			wantErr: `invalid grammar.Rename: username "zarel\n|/forfeit" contains reserved characters`,
+		},
+		{
+			name:    "rename with an extra argument",
+			msg:     Rename{Username: "zarel,1", Assertion: "abc"},
+			wantErr: `invalid grammar.Rename: username "zarel,1" contains reserved characters`,
+		},
+		{
+			name:    "rename with a long name",
+			msg:     Rename{Username: "Zarel the Great Two", Assertion: "abc"},
+			wantErr: `invalid grammar.Rename: username "Zarel the Great Two" is longer than 18 characters`,
+		},
+		{
+			name:    "rename without assertion",
+			msg:     Rename{Username: "zarel"},
+			wantErr: "invalid grammar.Rename: assertion is required",
+		},
+		{
+			name:    "rename with newline in assertion",
+			msg:     Rename{Username: "zarel", Assertion: "abc\n|/logout"},
+			wantErr: "invalid grammar.Rename: assertion contains a newline",
+		},
+		{
+			name:    "challenge smuggling a second command",
+			msg:     Challenge{User: "Zarel\n|/forfeit", Format: "[Gen 9] OU"},
+			wantErr: `invalid grammar.Challenge: user "Zarel\n|/forfeit" contains reserved characters`,
+		},
+		{
+			name:    "accept with an extra argument",
+			msg:     Accept{User: "zarel, gen9ou"},
+			wantErr: `invalid grammar.Accept: user "zarel, gen9ou" contains reserved characters`,
+		},
+		{
+			name:    "reject smuggling a second command",
+			msg:     Reject{User: "zarel|/logout"},
+			wantErr: `invalid grammar.Reject: user "zarel|/logout" contains reserved characters`,
+		},
+		{
+			name:    "cancel challenge with a carriage return",
+			msg:     CancelChallenge{User: "zarel\r"},
+			wantErr: `invalid grammar.CancelChallenge: user "zarel\r" contains reserved characters`,
+		},
+		{
+			name:    "ignore with an extra argument",
+			msg:     Ignore{User: "Some User, Jr."},
+			wantErr: `invalid grammar.Ignore: user "Some User, Jr." contains reserved characters`,
+		},
+		{
+			name:    "unignore smuggling a second command",
+			msg:     Unignore{User: "zarel\n/logout"},
+			wantErr: `invalid grammar.Unignore: user "zarel\n/logout" contains reserved characters`,
+		},
+		{
+			name:    "tour challenge with an extra argument",
+			msg:     TourChallenge{Room: "lobby", User: "zarel,1"},
+			wantErr: `invalid grammar.TourChallenge: user "zarel,1" contains reserved characters`,
+		},
+		{
+			name:    "multiline raw command",
+			msg:     RawCommand{Command: "|/join lobby\n|/logout"},
+			wantErr: "invalid grammar.RawCommand: message spans multiple lines",
+		},
+		{
+			name:    "invalid choice",
+			msg:     Choose{Room: "battle-gen9ou-1"},
+			wantErr: "invalid grammar.Choose: at least one choice is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.msg.Serialize()
+			require.EqualError(t, err, tt.wantErr)
+			require.Empty(t, got)
+		})
+	}
+}
+
+func TestValidator_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Validator
+		wantErr string
+	}{
+		{
+			name:    "missing room",
+			msg:     Choose{Choices: []Choice{MoveChoice{Slot: 1}}},
+			wantErr: "room is required",
+		},
+		{
+			name:    "no choices",
+			msg:     Choose{Room: "battle-gen9ou-1"},
+			wantErr: "at least one choice is required",
+		},
+		{
+			name:    "slot and name",
+			msg:     Choose{Room: "battle-gen9ou-1", Choices: []Choice{MoveChoice{Slot: 1, Name: "Earthquake"}}},
+			wantErr: "invalid choice 1: move slot and name are mutually exclusive",
+		},
+		{
+			name:    "move slot out of range",
+			msg:     Choose{Room: "battle-gen9ou-1", Choices: []Choice{MoveChoice{Slot: 5}}},
+			wantErr: "invalid choice 1: move slot 5 out of range [1, 4]",
+		},
+		{
+			name:    "move name smuggling another choice",
+			msg:     Choose{Room: "battle-gen9ou-1", Choices: []Choice{MoveChoice{Name: "Earthquake, switch 2"}}},
+			wantErr: `invalid choice 1: move name "Earthquake, switch 2" contains reserved characters`,
+		},
+		{
+			name:    "unknown modifier",
+			msg:     Choose{Room: "battle-gen9ou-1", Choices: []Choice{MoveChoice{Slot: 1, Modifier: "ultra"}}},
+			wantErr: `invalid choice 1: unknown move modifier "ultra"`,
+		},
+		{
+			name:    "target out of range",
+			msg:     Choose{Room: "battle-gen9ou-1", Choices: []Choice{PassChoice{}, MoveChoice{Slot: 1, Target: 4}}},
+			wantErr: "invalid choice 2: move target 4 out of range [-3, 3]",
+		},
+		{
+			name:    "default with other choices",
+			msg:     Choose{Room: "battle-gen9ou-1", Choices: []Choice{DefaultChoice{}, PassChoice{}}},
+			wantErr: "default cannot be combined with other choices",
+		},
+		{
+			name:    "switch without target",
+			msg:     Choose{Room: "battle-gen9ou-1", Choices: []Choice{SwitchChoice{}}},
+			wantErr: "invalid choice 1: switch slot or name is required",
+		},
+		{
+			name:    "duplicate team position",
+			msg:     TeamOrder{Room: "battle-gen9ou-1", Order: []int{1, 2, 1}},
+			wantErr: "team position 1 listed more than once",
+		},
+		{
+			name:    "room with newline",
+			msg:     Undo{Room: "battle-gen9ou-1\n|/forfeit"},
+			wantErr: `room "battle-gen9ou-1\n|/forfeit" contains reserved characters`,
+		},
+		{
+			name:    "search without format",
+			msg:     Search{},
+			wantErr: "format is required",
+		},
+		{
+			name:    "team without species",
+			msg:     UseTeam{Team: Team{{Species: "Garchomp"}, {Nickname: "Chompy"}}},
+			wantErr: "pokemon 2: species is required",
+		},
+		{
+			name:    "team with level out of range",
+			msg:     UseTeam{Team: Team{{Species: "Garchomp", Level: 101}}},
+			wantErr: "pokemon 1: level 101 out of range [1, 100], or 0 for level 100",
+		},
+		{
+			name:    "accept without user",
+			msg:     Accept{},
+			wantErr: "user is required",
+		},
+		{
+			name:    "ignore without an id",
+			msg:     Ignore{User: "!!!"},
+			wantErr: "user is required",
+		},
+		{
+			name:    "unknown query",
+			msg:     Query{Type: "everything"},
+			wantErr: `unknown query type "everything"`,
+		},
+		{
+			name:    "tournament without type",
+			msg:     TourNew{Room: "tournaments", Format: "gen9ou"},
+			wantErr: "tournament type is required",
+		},
+		{
+			name:    "tournament without room",
+			msg:     TourStart{},
+			wantErr: "room is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.EqualError(t, tt.msg.Validate(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package grammar
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Team is a list of pokemon sets, as used by `/utm` and the team builder
+type Team []PokemonSet
+
+type PokemonSet struct {
+	// Nickname can be left empty to use the species name
+	Nickname string
+	Species  string // required
+	Item     string
+	Ability  string
+	Moves    []string
+	Nature   string
+	// EVs can be left nil for no EVs
+	EVs *Stats
+	// Gender is "M", "F", or empty for the species default
+	Gender string
+	// IVs can be left nil for perfect IVs
+	IVs   *Stats
+	Shiny bool
+	// Level can be left at zero for level 100
+	Level int
+	// Happiness can be left nil for max happiness
+	Happiness       *int
+	Pokeball        string
+	HiddenPowerType string
+	Gigantamax      bool
+	// DynamaxLevel can be left nil for max dynamax level
+	DynamaxLevel *int
+	TeraType     string
+}
+
+type Stats struct {
+	HP  int
+	Atk int
+	Def int
+	SpA int
+	SpD int
+	Spe int
+}
+
+const (
+	maxLevel        = 100
+	maxHappiness    = 255
+	maxDynamaxLevel = 10
+	maxIV           = 31
+	maxTeamSize     = 24
+)
+
+// packedReserved are the separators of the packed format, which can't be escaped
+const packedReserved = "|],\r\n"
+
+func (t Team) Validate() error {
+	if len(t) > maxTeamSize {
+		return fmt.Errorf("team has %d pokemon, more than the maximum of %d", len(t), maxTeamSize)
+	}
+	for i, set := range t {
+		if err := set.Validate(); err != nil {
+			return fmt.Errorf("pokemon %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s PokemonSet) Validate() error {
+	if packName(s.Species) == "" {
+		return errors.New("species is required")
+	}
+	fields := []struct{ name, value string }{
+		{"nickname", s.Nickname},
+		{"species", s.Species},
+		{"nature", s.Nature},
+		{"gender", s.Gender},
+		{"hidden power type", s.HiddenPowerType},
+		{"tera type", s.TeraType},
+	}
+	for _, f := range fields {
+		if strings.ContainsAny(f.value, packedReserved) {
+			return fmt.Errorf("%s %q contains reserved characters", f.name, f.value)
+		}
+	}
+	switch s.Gender {
+	case "", "M", "F", "N":
+	default:
+		return fmt.Errorf("unknown gender %q", s.Gender)
+	}
+	if s.Level < 0 || s.Level > maxLevel {
+		return fmt.Errorf("level %d out of range [1, %d], or 0 for level %d", s.Level, maxLevel, maxLevel)
+	}
+	if s.Happiness != nil && (*s.Happiness < 0 || *s.Happiness > maxHappiness) {
+		return fmt.Errorf("happiness %d out of range [0, %d]", *s.Happiness, maxHappiness)
+	}
+	if s.DynamaxLevel != nil && (*s.DynamaxLevel < 0 || *s.DynamaxLevel > maxDynamaxLevel) {
+		return fmt.Errorf("dynamax level %d out of range [0, %d]", *s.DynamaxLevel, maxDynamaxLevel)
+	}
+	return nil
+}
+
+// Pack converts the team to Showdown's packed format, with sets separated by `]` and each set laid out as
+// NICKNAME|SPECIES|ITEM|ABILITY|MOVES|NATURE|EVS|GENDER|IVS|SHINY|LEVEL|HAPPINESS,HIDDENPOWERTYPE,POKEBALL,GIGANTAMAX,DYNAMAXLEVEL,TERATYPE
+//
+// The field order follows the simulator's Teams.pack. Items, abilities, moves, species (when nicknamed) and
+// pokeballs have everything but letters and digits removed, and fields left at their defaults are left blank.
+func (t Team) Pack() string {
+	sets := make([]string, 0, len(t))
+	for _, set := range t {
+		sets = append(sets, set.pack())
+	}
+	return strings.Join(sets, "]")
+}
+
+func (s PokemonSet) pack() string {
+	name := cmp.Or(s.Nickname, s.Species)
+	species := packName(s.Species)
+	if packName(name) == species {
+		species = ""
+	}
+	moves := make([]string, 0, len(s.Moves))
+	for _, move := range s.Moves {
+		moves = append(moves, packName(move))
+	}
+	level := ""
+	if s.Level != 0 && s.Level != maxLevel {
+		level = strconv.Itoa(s.Level)
+	}
+	happiness := ""
+	if s.Happiness != nil && *s.Happiness != maxHappiness {
+		happiness = strconv.Itoa(*s.Happiness)
+	}
+	shiny := ""
+	if s.Shiny {
+		shiny = "S"
+	}
+	fields := []string{
+		name,
+		species,
+		packName(s.Item),
+		packName(s.Ability),
+		strings.Join(moves, ","),
+		s.Nature,
+		packStats(s.EVs, 0),
+		s.Gender,
+		packStats(s.IVs, maxIV),
+		shiny,
+		level,
+		happiness,
+	}
+	packed := strings.Join(fields, "|")
+
+	dynamaxLevel := ""
+	if s.DynamaxLevel != nil && *s.DynamaxLevel != maxDynamaxLevel {
+		dynamaxLevel = strconv.Itoa(*s.DynamaxLevel)
+	}
+	if s.Pokeball != "" || s.HiddenPowerType != "" || s.Gigantamax || dynamaxLevel != "" || s.TeraType != "" {
+		gigantamax := ""
+		if s.Gigantamax {
+			gigantamax = "G"
+		}
+		packed += "," + strings.Join([]string{s.HiddenPowerType, packName(s.Pokeball), gigantamax, dynamaxLevel, s.TeraType}, ",")
+	}
+	return packed
+}
+
+// UnpackTeam parses a team in Showdown's packed format. Unpacking and then packing a team returns the original
+// string; fields set to their defaults in the packed format are left at their zero values.
+func UnpackTeam(packed string) (Team, error) {
+	if packed == "" {
+		return nil, nil
+	}
+	var team Team
+	for i, s := range strings.Split(packed, "]") {
+		set, err := unpackSet(s)
+		if err != nil {
+			return nil, fmt.Errorf("pokemon %d: %w", i+1, err)
+		}
+		team = append(team, set)
+	}
+	return team, nil
+}
+
+// packedFields is the number of `|` separated fields in a packed set
+const packedFields = 12
+
+func unpackSet(packed string) (PokemonSet, error) {
+	fields := strings.Split(packed, "|")
+	if len(fields) != packedFields {
+		return PokemonSet{}, fmt.Errorf("expected %d fields separated by |, got %d", packedFields, len(fields))
+	}
+	set := PokemonSet{
+		Species: fields[0],
+		Item:    fields[2],
+		Ability: fields[3],
+		Nature:  fields[5],
+		Gender:  fields[7],
+		Shiny:   fields[9] == "S",
+	}
+	if fields[1] != "" {
+		set.Nickname = fields[0]
+		set.Species = fields[1]
+	}
+	if fields[4] != "" {
+		set.Moves = strings.Split(fields[4], ",")
+	}
+	var err error
+	if set.EVs, err = unpackStats(fields[6], 0); err != nil {
+		return PokemonSet{}, fmt.Errorf("invalid EVs: %w", err)
+	}
+	if set.IVs, err = unpackStats(fields[8], maxIV); err != nil {
+		return PokemonSet{}, fmt.Errorf("invalid IVs: %w", err)
+	}
+	if fields[10] != "" {
+		if set.Level, err = strconv.Atoi(fields[10]); err != nil {
+			return PokemonSet{}, fmt.Errorf("invalid level %q", fields[10])
+		}
+	}
+
+	misc := strings.SplitN(fields[11], ",", 6)
+	if misc[0] != "" {
+		happiness, err := strconv.Atoi(misc[0])
+		if err != nil {
+			return PokemonSet{}, fmt.Errorf("invalid happiness %q", misc[0])
+		}
+		set.Happiness = &happiness
+	}
+	if len(misc) > 1 {
+		if len(misc) != 6 {
+			return PokemonSet{}, fmt.Errorf("expected 6 comma separated values after level, got %d", len(misc))
+		}
+		set.HiddenPowerType = misc[1]
+		set.Pokeball = misc[2]
+		set.Gigantamax = misc[3] != ""
+		if misc[4] != "" {
+			dynamaxLevel, err := strconv.Atoi(misc[4])
+			if err != nil {
+				return PokemonSet{}, fmt.Errorf("invalid dynamax level %q", misc[4])
+			}
+			set.DynamaxLevel = &dynamaxLevel
+		}
+		set.TeraType = misc[5]
+	}
+	return set, nil
+}
+
+// packStats packs stats in HP,ATK,DEF,SPA,SPD,SPE order, leaving stats at their default blank. Stats that are all at
+// their default pack to an empty string.
+func packStats(s *Stats, def int) string {
+	if s == nil {
+		return ""
+	}
+	values := []int{s.HP, s.Atk, s.Def, s.SpA, s.SpD, s.Spe}
+	packed := make([]string, 0, len(values))
+	allDefault := true
+	for _, v := range values {
+		if v == def {
+			packed = append(packed, "")
+			continue
+		}
+		allDefault = false
+		packed = append(packed, strconv.Itoa(v))
+	}
+	if allDefault {
+		return ""
+	}
+	return strings.Join(packed, ",")
+}
+
+func unpackStats(packed string, def int) (*Stats, error) {
+	if packed == "" {
+		return nil, nil
+	}
+	parts := strings.Split(packed, ",")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("expected 6 comma separated values, got %d", len(parts))
+	}
+	values := make([]int, 0, len(parts))
+	for _, p := range parts {
+		if p == "" {
+			values = append(values, def)
+			continue
+		}
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stat %q", p)
+		}
+		values = append(values, v)
+	}
+	return &Stats{HP: values[0], Atk: values[1], Def: values[2], SpA: values[3], SpD: values[4], Spe: values[5]}, nil
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// packName strips a name down to the form used in packed teams, e.g. "Choice Scarf" -> "ChoiceScarf"
+func packName(name string) string {
+	return nonAlphanumeric.ReplaceAllString(name, "")
+}
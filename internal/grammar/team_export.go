@@ -0,0 +1,296 @@
+package grammar
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// ParseExport parses a team in the human-readable format used by the team builder's import/export, e.g.
+//
+//	Chompy (Garchomp) (M) @ Choice Scarf
+//	Ability: Rough Skin
+//	EVs: 252 Atk / 4 SpD / 252 Spe
+//	Jolly Nature
+//	- Earthquake
+//
+// Sets are separated by blank lines. Errors point at the offending line and column, and can be printed with Pretty.
+func ParseExport(data []byte) (Team, error) {
+	p := exportParser{data: data}
+	var team Team
+	var set *PokemonSet
+	for i, line := range strings.Split(string(data), "\n") {
+		p.line = i + 1
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimLeft(line, " \t")
+		p.indent = len(line) - len(trimmed)
+		trimmed = strings.TrimRight(trimmed, " \t")
+		if trimmed == "" {
+			if set != nil {
+				team = append(team, *set)
+				set = nil
+			}
+			continue
+		}
+		if set == nil {
+			s, err := p.parseNameLine(trimmed)
+			if err != nil {
+				return nil, err
+			}
+			set = &s
+			continue
+		}
+		if err := p.parseLine(set, trimmed); err != nil {
+			return nil, err
+		}
+	}
+	if set != nil {
+		team = append(team, *set)
+	}
+	return team, nil
+}
+
+type exportParser struct {
+	data []byte
+	// line is the current 1-indexed line number
+	line int
+	// indent is the number of bytes of leading whitespace trimmed from the current line
+	indent int
+}
+
+// errorf returns an error pointing at the given 0-indexed byte offset of the current (trimmed) line
+func (p *exportParser) errorf(offset int, format string, args ...any) error {
+	return &parserErr{
+		msg: p.data,
+		error: participle.Errorf(
+			lexer.Position{Line: p.line, Column: p.indent + offset + 1},
+			"unable to parse team: "+format,
+			args...,
+		),
+	}
+}
+
+func (p *exportParser) parseNameLine(line string) (PokemonSet, error) {
+	var set PokemonSet
+	if i := strings.LastIndex(line, " @ "); i != -1 {
+		set.Item = strings.TrimSpace(line[i+len(" @ "):])
+		line = strings.TrimSpace(line[:i])
+	}
+	for _, gender := range []string{"M", "F"} {
+		if suffix := fmt.Sprintf(" (%s)", gender); strings.HasSuffix(line, suffix) {
+			set.Gender = gender
+			line = strings.TrimSuffix(line, suffix)
+		}
+	}
+	set.Species = line
+	if strings.HasSuffix(line, ")") {
+		i := strings.LastIndex(line, " (")
+		if i == -1 {
+			return PokemonSet{}, p.errorf(len(line)-1, "unmatched parenthesis in %q", line)
+		}
+		set.Nickname = line[:i]
+		set.Species = line[i+len(" (") : len(line)-1]
+	}
+	if set.Species == "" {
+		return PokemonSet{}, p.errorf(0, "species is required")
+	}
+	return set, nil
+}
+
+func (p *exportParser) parseLine(set *PokemonSet, line string) error {
+	switch {
+	case strings.HasPrefix(line, "- "), strings.HasPrefix(line, "~ "):
+		set.Moves = append(set.Moves, strings.TrimSpace(line[2:]))
+		return nil
+	case strings.HasSuffix(line, " Nature"):
+		set.Nature = strings.TrimSuffix(line, " Nature")
+		return nil
+	}
+
+	key, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return p.errorf(0, "unrecognized line %q", line)
+	}
+	// offset of the value within the line, for errors
+	offset := len(key) + 1 + len(value) - len(strings.TrimLeft(value, " "))
+	value = strings.TrimSpace(value)
+	var err error
+	switch key {
+	case "Ability":
+		set.Ability = value
+	case "Level":
+		set.Level, err = p.parseInt(value, offset, 1, maxLevel)
+	case "Shiny":
+		set.Shiny, err = p.parseYesNo(value, offset)
+	case "Happiness":
+		var happiness int
+		happiness, err = p.parseInt(value, offset, 0, maxHappiness)
+		set.Happiness = &happiness
+	case "Pokeball":
+		set.Pokeball = value
+	case "Hidden Power":
+		set.HiddenPowerType = value
+	case "Dynamax Level":
+		var dynamaxLevel int
+		dynamaxLevel, err = p.parseInt(value, offset, 0, maxDynamaxLevel)
+		set.DynamaxLevel = &dynamaxLevel
+	case "Gigantamax":
+		set.Gigantamax, err = p.parseYesNo(value, offset)
+	case "Tera Type":
+		set.TeraType = value
+	case "EVs":
+		set.EVs, err = p.parseStats(value, offset, 0)
+	case "IVs":
+		set.IVs, err = p.parseStats(value, offset, maxIV)
+	default:
+		return p.errorf(0, "unrecognized field %q", key)
+	}
+	return err
+}
+
+func (p *exportParser) parseInt(value string, offset, low, high int) (int, error) {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, p.errorf(offset, "expected a number, got %q", value)
+	}
+	if v < low || v > high {
+		return 0, p.errorf(offset, "%d out of range [%d, %d]", v, low, high)
+	}
+	return v, nil
+}
+
+func (p *exportParser) parseYesNo(value string, offset int) (bool, error) {
+	switch value {
+	case "Yes":
+		return true, nil
+	case "No":
+		return false, nil
+	default:
+		return false, p.errorf(offset, `expected "Yes" or "No", got %q`, value)
+	}
+}
+
+// parseStats parses stat spreads like `252 Atk / 4 SpD / 252 Spe`, with unlisted stats set to def
+func (p *exportParser) parseStats(value string, offset, def int) (*Stats, error) {
+	s := &Stats{HP: def, Atk: def, Def: def, SpA: def, SpD: def, Spe: def}
+	for _, part := range strings.Split(value, "/") {
+		partOffset := offset + len(part) - len(strings.TrimLeft(part, " "))
+		offset += len(part) + len("/")
+		n, stat, ok := strings.Cut(strings.TrimSpace(part), " ")
+		if !ok {
+			return nil, p.errorf(partOffset, "expected a stat like \"252 Atk\", got %q", strings.TrimSpace(part))
+		}
+		v, err := strconv.Atoi(n)
+		if err != nil {
+			return nil, p.errorf(partOffset, "expected a number, got %q", n)
+		}
+		field, ok := map[string]*int{
+			"hp":  &s.HP,
+			"atk": &s.Atk,
+			"def": &s.Def,
+			"spa": &s.SpA,
+			"spd": &s.SpD,
+			"spe": &s.Spe,
+		}[strings.ToLower(strings.TrimSpace(stat))]
+		if !ok {
+			return nil, p.errorf(partOffset+len(n)+1, "unknown stat %q", strings.TrimSpace(stat))
+		}
+		*field = v
+	}
+	return s, nil
+}
+
+// Export prints the team in the human-readable format read by ParseExport and the team builder. Fields left at
+// their defaults are omitted.
+func (t Team) Export() string {
+	b := bytes.Buffer{}
+	for i, set := range t {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		set.export(&b)
+	}
+	return b.String()
+}
+
+func (s PokemonSet) export(b *bytes.Buffer) {
+	if s.Nickname != "" && s.Nickname != s.Species {
+		b.WriteString(fmt.Sprintf("%s (%s)", s.Nickname, s.Species))
+	} else {
+		b.WriteString(s.Species)
+	}
+	if s.Gender == "M" || s.Gender == "F" {
+		b.WriteString(fmt.Sprintf(" (%s)", s.Gender))
+	}
+	if s.Item != "" {
+		b.WriteString(fmt.Sprintf(" @ %s", s.Item))
+	}
+	b.WriteString("\n")
+
+	if s.Ability != "" {
+		b.WriteString(fmt.Sprintf("Ability: %s\n", s.Ability))
+	}
+	if s.Level != 0 && s.Level != maxLevel {
+		b.WriteString(fmt.Sprintf("Level: %d\n", s.Level))
+	}
+	if s.Shiny {
+		b.WriteString("Shiny: Yes\n")
+	}
+	if s.Happiness != nil && *s.Happiness != maxHappiness {
+		b.WriteString(fmt.Sprintf("Happiness: %d\n", *s.Happiness))
+	}
+	if s.Pokeball != "" {
+		b.WriteString(fmt.Sprintf("Pokeball: %s\n", s.Pokeball))
+	}
+	if s.HiddenPowerType != "" {
+		b.WriteString(fmt.Sprintf("Hidden Power: %s\n", s.HiddenPowerType))
+	}
+	if s.DynamaxLevel != nil && *s.DynamaxLevel != maxDynamaxLevel {
+		b.WriteString(fmt.Sprintf("Dynamax Level: %d\n", *s.DynamaxLevel))
+	}
+	if s.Gigantamax {
+		b.WriteString("Gigantamax: Yes\n")
+	}
+	if s.TeraType != "" {
+		b.WriteString(fmt.Sprintf("Tera Type: %s\n", s.TeraType))
+	}
+	if evs := exportStats(s.EVs, 0); evs != "" {
+		b.WriteString(fmt.Sprintf("EVs: %s\n", evs))
+	}
+	if s.Nature != "" {
+		b.WriteString(fmt.Sprintf("%s Nature\n", s.Nature))
+	}
+	if ivs := exportStats(s.IVs, maxIV); ivs != "" {
+		b.WriteString(fmt.Sprintf("IVs: %s\n", ivs))
+	}
+	for _, move := range s.Moves {
+		b.WriteString(fmt.Sprintf("- %s\n", move))
+	}
+}
+
+func exportStats(s *Stats, def int) string {
+	if s == nil {
+		return ""
+	}
+	var parts []string
+	for _, stat := range []struct {
+		name  string
+		value int
+	}{
+		{"HP", s.HP},
+		{"Atk", s.Atk},
+		{"Def", s.Def},
+		{"SpA", s.SpA},
+		{"SpD", s.SpD},
+		{"Spe", s.Spe},
+	} {
+		if stat.value != def {
+			parts = append(parts, fmt.Sprintf("%d %s", stat.value, stat.name))
+		}
+	}
+	return strings.Join(parts, " / ")
+}
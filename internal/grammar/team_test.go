@@ -0,0 +1,307 @@
+package grammar
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnpackTeam(t *testing.T) {
+	zero, five := 0, 5
+	tests := []struct {
+		name   string
+		packed string
+		want   Team
+	}{
+		{
+			name:   "empty",
+			packed: "",
+			want:   nil,
+		},
+		{
+			name:   "gen 9 ou",
+			packed: `Great Tusk||BoosterEnergy|Protosynthesis|HeadlongRush,IceSpinner,RapidSpin,KnockOff|Jolly|,252,4,,,252|||||,,,,,Ground]Kingambit||BlackGlasses|SupremeOverlord|SwordsDance,KowtowCleave,SuckerPunch,IronHead|Adamant|252,252,,,4,|||||,,,,,Dark]Iron Valiant||BoosterEnergy|QuarkDrive|Moonblast,ShadowBall,Psyshock,Thunderbolt|Timid|,,,252,4,252||,0,,,,|||,,,,,Fairy`,
+			want: Team{
+				{
+					Species:  "Great Tusk",
+					Item:     "BoosterEnergy",
+					Ability:  "Protosynthesis",
+					Moves:    []string{"HeadlongRush", "IceSpinner", "RapidSpin", "KnockOff"},
+					Nature:   "Jolly",
+					EVs:      &Stats{Atk: 252, Def: 4, Spe: 252},
+					TeraType: "Ground",
+				},
+				{
+					Species:  "Kingambit",
+					Item:     "BlackGlasses",
+					Ability:  "SupremeOverlord",
+					Moves:    []string{"SwordsDance", "KowtowCleave", "SuckerPunch", "IronHead"},
+					Nature:   "Adamant",
+					EVs:      &Stats{HP: 252, Atk: 252, SpD: 4},
+					TeraType: "Dark",
+				},
+				{
+					Species:  "Iron Valiant",
+					Item:     "BoosterEnergy",
+					Ability:  "QuarkDrive",
+					Moves:    []string{"Moonblast", "ShadowBall", "Psyshock", "Thunderbolt"},
+					Nature:   "Timid",
+					EVs:      &Stats{SpA: 252, SpD: 4, Spe: 252},
+					IVs:      &Stats{HP: 31, Atk: 0, Def: 31, SpA: 31, SpD: 31, Spe: 31},
+					TeraType: "Fairy",
+				},
+			},
+		},
+		{
+			name:   "gen 8 nicknamed gigantamax",
+			packed: `Sparky|Toxtricity|ThroatSpray|PunkRock|Overdrive,BoomBurst,SludgeBomb,VoltSwitch|Modest|,,,252,4,252|F||S|50|0,,PokeBall,G,5,`,
+			want: Team{
+				{
+					Nickname:     "Sparky",
+					Species:      "Toxtricity",
+					Item:         "ThroatSpray",
+					Ability:      "PunkRock",
+					Moves:        []string{"Overdrive", "BoomBurst", "SludgeBomb", "VoltSwitch"},
+					Nature:       "Modest",
+					EVs:          &Stats{SpA: 252, SpD: 4, Spe: 252},
+					Gender:       "F",
+					Shiny:        true,
+					Level:        50,
+					Happiness:    &zero,
+					Pokeball:     "PokeBall",
+					Gigantamax:   true,
+					DynamaxLevel: &five,
+				},
+			},
+		},
+		{
+			name:   "gen 3 hidden power",
+			packed: `Starmie||Leftovers|NaturalCure|Surf,Thunderbolt,IceBeam,RapidSpin|Timid|,,,252,4,252||,2,,30,,|||,Grass,,,,`,
+			want: Team{
+				{
+					Species:         "Starmie",
+					Item:            "Leftovers",
+					Ability:         "NaturalCure",
+					Moves:           []string{"Surf", "Thunderbolt", "IceBeam", "RapidSpin"},
+					Nature:          "Timid",
+					EVs:             &Stats{SpA: 252, SpD: 4, Spe: 252},
+					IVs:             &Stats{HP: 31, Atk: 2, Def: 31, SpA: 30, SpD: 31, Spe: 31},
+					HiddenPowerType: "Grass",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			team, err := UnpackTeam(tt.packed)
+			require.NoError(t, err)
+			if diff := cmp.Diff(tt.want, team); diff != "" {
+				t.Errorf("UnpackTeam() mismatch (-want +got):\n%s", diff)
+			}
+			require.NoError(t, team.Validate())
+			require.Equal(t, tt.packed, team.Pack())
+		})
+	}
+}
+
+func TestTeam_Pack(t *testing.T) {
+	hundred, maxed := 100, 255
+	team := Team{{
+		Nickname:  "Garchomp",
+		Species:   "Garchomp",
+		Item:      "Choice Scarf",
+		Ability:   "Rough Skin",
+		Moves:     []string{"Earthquake", "Outrage", "Stone Edge", "U-turn"},
+		Nature:    "Jolly",
+		EVs:       &Stats{},
+		IVs:       &Stats{HP: 31, Atk: 31, Def: 31, SpA: 31, SpD: 31, Spe: 31},
+		Level:     100,
+		Happiness: &maxed,
+	}, {
+		Species:   "Landorus-Therian",
+		Ability:   "Intimidate",
+		Happiness: &hundred,
+		TeraType:  "Water",
+	}}
+	require.Equal(
+		t,
+		`Garchomp||ChoiceScarf|RoughSkin|Earthquake,Outrage,StoneEdge,Uturn|Jolly||||||]Landorus-Therian|||Intimidate||||||||100,,,,,Water`,
+		team.Pack(),
+	)
+}
+
+func TestUnpackTeam_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		packed  string
+		wantErr string
+	}{
+		{
+			name:    "missing fields",
+			packed:  `Garchomp||ChoiceScarf|RoughSkin|Earthquake|Jolly`,
+			wantErr: "pokemon 1: expected 12 fields separated by |, got 6",
+		},
+		{
+			name:    "bad level",
+			packed:  `Pikachu|||Static|Thunderbolt|||||||]Garchomp||||||||||fifty|`,
+			wantErr: `pokemon 2: invalid level "fifty"`,
+		},
+		{
+			name:    "short EVs",
+			packed:  `Pikachu|||Static|Thunderbolt||252,252|||||`,
+			wantErr: "pokemon 1: invalid EVs: expected 6 comma separated values, got 2",
+		},
+		{
+			name:    "short misc",
+			packed:  `Pikachu|||Static|Thunderbolt|||||||,Electric`,
+			wantErr: "pokemon 1: expected 6 comma separated values after level, got 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := UnpackTeam(tt.packed)
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseExport(t *testing.T) {
+	five := 5
+	data := []byte(`Chompy (Garchomp) (M) @ Choice Scarf
+Ability: Rough Skin
+Tera Type: Ground
+EVs: 252 Atk / 4 SpD / 252 Spe
+Jolly Nature
+- Earthquake
+- Outrage
+- Stone Edge
+- U-turn
+
+Toxtricity (F) @ Throat Spray  
+Ability: Punk Rock
+Level: 50
+Shiny: Yes
+Pokeball: Poke Ball
+Dynamax Level: 5
+Gigantamax: Yes
+EVs: 252 SpA / 4 SpD / 252 Spe
+Modest Nature
+IVs: 0 Atk
+- Overdrive
+- Boomburst
+`)
+	want := Team{
+		{
+			Nickname: "Chompy",
+			Species:  "Garchomp",
+			Gender:   "M",
+			Item:     "Choice Scarf",
+			Ability:  "Rough Skin",
+			TeraType: "Ground",
+			EVs:      &Stats{Atk: 252, SpD: 4, Spe: 252},
+			Nature:   "Jolly",
+			Moves:    []string{"Earthquake", "Outrage", "Stone Edge", "U-turn"},
+		},
+		{
+			Species:      "Toxtricity",
+			Gender:       "F",
+			Item:         "Throat Spray",
+			Ability:      "Punk Rock",
+			Level:        50,
+			Shiny:        true,
+			Pokeball:     "Poke Ball",
+			DynamaxLevel: &five,
+			Gigantamax:   true,
+			EVs:          &Stats{SpA: 252, SpD: 4, Spe: 252},
+			Nature:       "Modest",
+			IVs:          &Stats{HP: 31, Atk: 0, Def: 31, SpA: 31, SpD: 31, Spe: 31},
+			Moves:        []string{"Overdrive", "Boomburst"},
+		},
+	}
+	team, err := ParseExport(data)
+	require.NoError(t, err, Pretty(err))
+	if diff := cmp.Diff(want, team); diff != "" {
+		t.Errorf("ParseExport() mismatch (-want +got):\n%s", diff)
+	}
+	require.Equal(
+		t,
+		`Chompy|Garchomp|ChoiceScarf|RoughSkin|Earthquake,Outrage,StoneEdge,Uturn|Jolly|,252,,,4,252|M||||,,,,,Ground]`+
+			`Toxtricity||ThroatSpray|PunkRock|Overdrive,Boomburst|Modest|,,,252,4,252|F|,0,,,,|S|50|,,PokeBall,G,5,`,
+		team.Pack(),
+	)
+
+	// Exporting and parsing again gives back the same team
+	reparsed, err := ParseExport([]byte(team.Export()))
+	require.NoError(t, err, Pretty(err))
+	if diff := cmp.Diff(team, reparsed); diff != "" {
+		t.Errorf("ParseExport(Export()) mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTeam_Export(t *testing.T) {
+	team, err := UnpackTeam(`Great Tusk||BoosterEnergy|Protosynthesis|HeadlongRush,IceSpinner,RapidSpin,KnockOff|Jolly|,252,4,,,252|||||,,,,,Ground]Starmie||Leftovers|NaturalCure|Surf,Thunderbolt|Timid|,,,252,4,252||,2,,30,,|||,Grass,,,,`)
+	require.NoError(t, err)
+	require.Equal(t, `Great Tusk @ BoosterEnergy
+Ability: Protosynthesis
+Tera Type: Ground
+EVs: 252 Atk / 4 Def / 252 Spe
+Jolly Nature
+- HeadlongRush
+- IceSpinner
+- RapidSpin
+- KnockOff
+
+Starmie @ Leftovers
+Ability: NaturalCure
+Hidden Power: Grass
+EVs: 252 SpA / 4 SpD / 252 Spe
+Timid Nature
+IVs: 2 Atk / 30 SpA
+- Surf
+- Thunderbolt
+`, team.Export())
+}
+
+func TestParseExport_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantErr    string
+		wantPretty string
+	}{
+		{
+			name:    "unknown stat",
+			data:    "Garchomp @ Choice Scarf\nEVs: 252 Atk / 4 Foo\n- Earthquake",
+			wantErr: `2:18: unable to parse team: unknown stat "Foo"`,
+			wantPretty: "> Garchomp @ Choice Scarf\n" +
+				"> EVs: 252 Atk / 4 Foo\n" +
+				"  .................^",
+		},
+		{
+			name:    "bad level",
+			data:    "Pikachu\n\nGarchomp\n  Level: fifty",
+			wantErr: `4:10: unable to parse team: expected a number, got "fifty"`,
+			wantPretty: "> Pikachu\n" +
+				"> \n" +
+				"> Garchomp\n" +
+				">   Level: fifty\n" +
+				"  .........^",
+		},
+		{
+			name:    "unrecognized line",
+			data:    "Garchomp\nJolly",
+			wantErr: `2:1: unable to parse team: unrecognized line "Jolly"`,
+			wantPretty: "> Garchomp\n" +
+				"> Jolly\n" +
+				"  ^",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseExport([]byte(tt.data))
+			require.EqualError(t, err, tt.wantErr)
+			require.Equal(t, tt.wantPretty, Pretty(err))
+		})
+	}
+}
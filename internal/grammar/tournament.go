@@ -0,0 +1,331 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TourType string
+
+const (
+	Elimination TourType = "elimination"
+	RoundRobin  TourType = "roundrobin"
+)
+
+func (t TourType) validate() error {
+	switch t {
+	case Elimination, RoundRobin:
+		return nil
+	case "":
+		return errors.New("tournament type is required")
+	default:
+		return fmt.Errorf("unknown tournament type %q", t)
+	}
+}
+
+// TourNew creates a tournament, e.g. `tournaments|/tour new gen9ou, elimination, 64, 2, Monthly OU`
+type TourNew struct {
+	Room   string   // required
+	Format string   // required
+	Type   TourType // required
+	// PlayerCap can be left at zero for no cap
+	PlayerCap int
+	// Rounds is the number of times each pair plays in round robin, or the number of losses needed to be
+	// eliminated in elimination. It can be left at zero for the default of 1.
+	Rounds int
+	Name   string
+}
+
+func (t TourNew) Validate() error {
+	if err := validateRoom(t.Room); err != nil {
+		return err
+	}
+	if ToID(t.Format) == "" {
+		return errors.New("format is required")
+	}
+	if err := t.Type.validate(); err != nil {
+		return err
+	}
+	if t.PlayerCap < 0 {
+		return fmt.Errorf("invalid player cap %d", t.PlayerCap)
+	}
+	if t.Rounds < 0 {
+		return fmt.Errorf("invalid rounds %d", t.Rounds)
+	}
+	return nil
+}
+
+func (t TourNew) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourNew) command() string {
+	args := []string{ToID(t.Format), string(t.Type)}
+	// Later arguments are positional, so earlier ones are filled in with their defaults when needed
+	if t.PlayerCap != 0 || t.Rounds != 0 || t.Name != "" {
+		args = append(args, strconv.Itoa(t.PlayerCap))
+	}
+	if t.Rounds != 0 || t.Name != "" {
+		args = append(args, strconv.Itoa(max(t.Rounds, 1)))
+	}
+	if name := escapeArg(t.Name); name != "" {
+		args = append(args, name)
+	}
+	return tour(t.Room, "new", args...)
+}
+
+type TourJoin struct {
+	Room string // required
+}
+
+func (t TourJoin) Validate() error {
+	return validateRoom(t.Room)
+}
+
+func (t TourJoin) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourJoin) command() string {
+	return tour(t.Room, "join")
+}
+
+type TourLeave struct {
+	Room string // required
+}
+
+func (t TourLeave) Validate() error {
+	return validateRoom(t.Room)
+}
+
+func (t TourLeave) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourLeave) command() string {
+	return tour(t.Room, "leave")
+}
+
+type TourStart struct {
+	Room string // required
+}
+
+func (t TourStart) Validate() error {
+	return validateRoom(t.Room)
+}
+
+func (t TourStart) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourStart) command() string {
+	return tour(t.Room, "start")
+}
+
+type TourEnd struct {
+	Room string // required
+}
+
+func (t TourEnd) Validate() error {
+	return validateRoom(t.Room)
+}
+
+func (t TourEnd) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourEnd) command() string {
+	return tour(t.Room, "end")
+}
+
+// TourChallenge challenges the opponent assigned to us by the tournament bracket
+type TourChallenge struct {
+	Room string // required
+	User string // required
+}
+
+func (t TourChallenge) Validate() error {
+	if err := validateRoom(t.Room); err != nil {
+		return err
+	}
+	return validateUser(t.User)
+}
+
+func (t TourChallenge) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourChallenge) command() string {
+	return tour(t.Room, "challenge", ToID(t.User))
+}
+
+type TourAcceptChallenge struct {
+	Room string // required
+}
+
+func (t TourAcceptChallenge) Validate() error {
+	return validateRoom(t.Room)
+}
+
+func (t TourAcceptChallenge) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourAcceptChallenge) command() string {
+	return tour(t.Room, "acceptchallenge")
+}
+
+// TourAutoStart starts the tournament once Timeout has passed since it was created
+type TourAutoStart struct {
+	Room string // required
+	// Timeout can be left at zero to turn auto start off
+	Timeout time.Duration
+}
+
+func (t TourAutoStart) Validate() error {
+	if err := validateRoom(t.Room); err != nil {
+		return err
+	}
+	if t.Timeout < 0 {
+		return fmt.Errorf("invalid timeout %s", t.Timeout)
+	}
+	return nil
+}
+
+func (t TourAutoStart) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourAutoStart) command() string {
+	return tour(t.Room, "autostart", minutesOrOff(t.Timeout))
+}
+
+// TourAutoDQ disqualifies players that haven't started their battle once Timeout has passed
+type TourAutoDQ struct {
+	Room string // required
+	// Timeout can be left at zero to turn auto disqualification off
+	Timeout time.Duration
+}
+
+func (t TourAutoDQ) Validate() error {
+	if err := validateRoom(t.Room); err != nil {
+		return err
+	}
+	if t.Timeout < 0 {
+		return fmt.Errorf("invalid timeout %s", t.Timeout)
+	}
+	return nil
+}
+
+func (t TourAutoDQ) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourAutoDQ) command() string {
+	return tour(t.Room, "autodq", minutesOrOff(t.Timeout))
+}
+
+// TourRules adds custom rules to the tournament's format, e.g. "-Garchomp", "+Landorus-Therian", "Sleep Clause Mod"
+type TourRules struct {
+	Room  string   // required
+	Rules []string // required
+}
+
+func (t TourRules) Validate() error {
+	if err := validateRoom(t.Room); err != nil {
+		return err
+	}
+	if len(t.Rules) == 0 {
+		return errors.New("at least one rule is required")
+	}
+	for _, rule := range t.Rules {
+		if escapeArg(rule) == "" {
+			return errors.New("rules cannot be empty")
+		}
+	}
+	return nil
+}
+
+func (t TourRules) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourRules) command() string {
+	rules := make([]string, 0, len(t.Rules))
+	for _, rule := range t.Rules {
+		rules = append(rules, escapeArg(rule))
+	}
+	return tour(t.Room, "rules", rules...)
+}
+
+// TourSetType changes the bracket type of a tournament that hasn't started yet
+type TourSetType struct {
+	Room string   // required
+	Type TourType // required
+	// Rounds can be left at zero for the default of 1
+	Rounds int
+}
+
+func (t TourSetType) Validate() error {
+	if err := validateRoom(t.Room); err != nil {
+		return err
+	}
+	if err := t.Type.validate(); err != nil {
+		return err
+	}
+	if t.Rounds < 0 {
+		return fmt.Errorf("invalid rounds %d", t.Rounds)
+	}
+	return nil
+}
+
+func (t TourSetType) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourSetType) command() string {
+	if t.Rounds != 0 {
+		return tour(t.Room, "settype", string(t.Type), strconv.Itoa(t.Rounds))
+	}
+	return tour(t.Room, "settype", string(t.Type))
+}
+
+// TourForceTimer turns the battle timer on for every battle in the tournament
+type TourForceTimer struct {
+	Room string // required
+	On   bool
+}
+
+func (t TourForceTimer) Validate() error {
+	return validateRoom(t.Room)
+}
+
+func (t TourForceTimer) Serialize() (string, error) {
+	return serialize(t)
+}
+
+func (t TourForceTimer) command() string {
+	if t.On {
+		return tour(t.Room, "forcetimer", "on")
+	}
+	return tour(t.Room, "forcetimer", "off")
+}
+
+// tour builds a tournament command, which is scoped to the chat room hosting the tournament, e.g.
+// `tournaments|/tour join`
+func tour(room, subcommand string, args ...string) string {
+	if len(args) == 0 {
+		return fmt.Sprintf("%s|/tour %s", room, subcommand)
+	}
+	return fmt.Sprintf("%s|/tour %s %s", room, subcommand, strings.Join(args, ", "))
+}
+
+func minutesOrOff(d time.Duration) string {
+	if d == 0 {
+		return "off"
+	}
+	return strconv.FormatFloat(d.Minutes(), 'f', -1, 64)
+}